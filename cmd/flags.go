@@ -2,6 +2,7 @@ package main
 
 import (
 	goflag "flag"
+	"fmt"
 	flag "github.com/spf13/pflag"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
@@ -24,13 +25,13 @@ func parseFlags() *Flags {
 	flags := &Flags{}
 	klog.InitFlags(nil)
 	flags.overrides = &clientcmd.ConfigOverrides{}
-	// clientcmd.BindOverrideFlags(
-	// 	flags.overrides, flag.CommandLine,
-	// 	clientcmd.ConfigOverrideFlags{
-	// 		CurrentContext: clientcmd.FlagInfo{
-	// 			clientcmd.FlagContext, "", "", "The name of the kubeconfig context to use",
-	// 		},
-	// 	})
+	clientcmd.BindOverrideFlags(
+		flags.overrides, flag.CommandLine,
+		clientcmd.ConfigOverrideFlags{
+			CurrentContext: clientcmd.FlagInfo{
+				LongName: clientcmd.FlagContext, Description: "The name of the kubeconfig context to use",
+			},
+		})
 
 	flag.BoolVar(&flags.version, "version", false, "Print version and exit")
 
@@ -40,8 +41,25 @@ func parseFlags() *Flags {
 	flag.BoolVar(&flags.deploymentAffinity, "deployment-affinity", false, "Setup deployment affinity webhook")
 	flag.BoolVar(&flags.ingressRewriteTarget, "ingress-rewrite-target", false, "Setup ingress rewrite-target webhook")
 	flag.BoolVar(&flags.jiveWebAppsAffinity, "jive-webapps-affinity", false, "Setup ingress jive webapp affinity webhook")
+	for _, name := range []string{"deployment-affinity", "ingress-rewrite-target", "jive-webapps-affinity"} {
+		flag.CommandLine.MarkDeprecated(name, fmt.Sprintf("use --plugins=%s instead", name))
+	}
 
 	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
 	flag.Parse()
+
+	// the deprecated flags enable the plugin of the same name
+	for _, deprecated := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"deployment-affinity", flags.deploymentAffinity},
+		{"ingress-rewrite-target", flags.ingressRewriteTarget},
+		{"jive-webapps-affinity", flags.jiveWebAppsAffinity},
+	} {
+		if deprecated.enabled {
+			flags.wsFlags.Plugins = append(flags.wsFlags.Plugins, deprecated.name)
+		}
+	}
 	return flags
 }
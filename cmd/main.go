@@ -13,11 +13,13 @@ import (
 	"k8s.io/klog"
 
 	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks/manager"
 	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks/server"
 
-	"github.com/trilogy-group/k8s-webhooks/pkg/plugins/affinity"
-	"github.com/trilogy-group/k8s-webhooks/pkg/plugins/ingress"
-	"github.com/trilogy-group/k8s-webhooks/pkg/plugins/jivewebappaffinity"
+	// built-in plugins, registered with the manager
+	_ "github.com/trilogy-group/k8s-webhooks/pkg/plugins/affinity"
+	_ "github.com/trilogy-group/k8s-webhooks/pkg/plugins/ingress"
+	_ "github.com/trilogy-group/k8s-webhooks/pkg/plugins/jivewebappaffinity"
 )
 
 var version string
@@ -30,33 +32,52 @@ func main() {
 		os.Exit(0)
 	}
 
+	config := &webhooks.WebhookServerConfig{
+		DefaultAdmitPolicy:  flags.wsFlags.DefaultAdmitPolicy,
+		HandlerTimeout:      flags.wsFlags.HandlerTimeout,
+		MaxRequestBodyBytes: flags.wsFlags.MaxRequestBodyBytes,
+		Plugins:             flags.wsFlags.Plugins,
+		PluginsDir:          flags.wsFlags.PluginsDir,
+		UseConfigMap:        flags.wsFlags.UseConfigMap,
+		Kubeconfig:          flags.wsFlags.Kubeconfig,
+		CmNamespace:         flags.wsFlags.CmNamespace,
+		CmName:              flags.wsFlags.CmName,
+		KubeOverrides:       flags.overrides,
+		KubeAPIQPS:          flags.wsFlags.KubeAPIQPS,
+		KubeAPIBurst:        flags.wsFlags.KubeAPIBurst,
+		UserAgent:           flags.wsFlags.UserAgent,
+
+		SelfSignedCerts:                 flags.wsFlags.SelfSignedCerts,
+		CertsSecretNamespace:            flags.wsFlags.CertsSecretNamespace,
+		CertsSecretName:                 flags.wsFlags.CertsSecretName,
+		ServiceName:                     flags.wsFlags.ServiceName,
+		MutatingWebhookConfigurations:   flags.wsFlags.MutatingWebhookConfigurations,
+		ValidatingWebhookConfigurations: flags.wsFlags.ValidatingWebhookConfigurations,
+	}
+	if err := config.Validate(); err != nil {
+		klog.Fatalf("Invalid configuration: %v", err)
+	}
+
 	ws := server.NewWebhookServer(
-		&webhooks.WebhookServerConfig{
-			DefaultAdmitPolicy: flags.wsFlags.DefaultAdmitPolicy,
-			UseConfigMap:       flags.wsFlags.UseConfigMap,
-			Kubeconfig:         flags.wsFlags.Kubeconfig,
-			CmNamespace:        flags.wsFlags.CmNamespace,
-			CmName:             flags.wsFlags.CmName,
-		},
+		config,
 		&webhooks.WhSrvParameters{
-			Port:     flags.wsFlags.Port,
-			CertFile: flags.wsFlags.CertFile,
-			KeyFile:  flags.wsFlags.KeyFile,
+			Port:        flags.wsFlags.Port,
+			CertFile:    flags.wsFlags.CertFile,
+			KeyFile:     flags.wsFlags.KeyFile,
+			MetricsPort: flags.wsFlags.MetricsPort,
 		})
 
-	if flags.deploymentAffinity {
-		wh := affinity.NewWebhookHandler()
-		wh.Setup(ws, "/deployment/affinity")
+	mgr := manager.NewWebhookManager(ws)
+	if err := manager.RegisterMetrics(mgr); err != nil {
+		klog.Errorf("Can't register plugins metrics: %v", err)
 	}
-
-	if flags.ingressRewriteTarget {
-		wh := ingress.NewWebhookHandler()
-		wh.Setup(ws, "/ingress/rewrite")
+	for name, err := range manager.EnablePlugins(mgr, ws.GetConfig().Plugins) {
+		klog.Errorf("Can't enable plugin %s: %v", name, err)
 	}
-
-	if flags.jiveWebAppsAffinity {
-		wh := jivewebappaffinity.NewWebhookHandler()
-		wh.Setup(ws, "/jive/webapp")
+	if ws.GetConfig().UseConfigMap {
+		if err := manager.WatchConfigMap(mgr, ws); err != nil {
+			klog.Errorf("Can't watch the plugins of the ConfigMap: %v", err)
+		}
 	}
 
 	go func() {
@@ -3,11 +3,13 @@ module github.com/trilogy-group/k8s-webhooks
 go 1.14
 
 require (
+	github.com/evanphx/json-patch v4.2.0+incompatible
 	github.com/googleapis/gnostic v0.4.0 // indirect
-	github.com/spf13/pflag v1.0.1
+	github.com/prometheus/client_golang v1.0.0
+	github.com/spf13/pflag v1.0.5
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
-	k8s.io/api v0.0.0-20190620084959-7cf5895f2711
-	k8s.io/apimachinery v0.0.0-20190612205821-1799e75a0719
-	k8s.io/client-go v0.0.0-20190620085101-78d2af792bab
-	k8s.io/klog v0.3.1
+	k8s.io/api v0.16.13
+	k8s.io/apimachinery v0.16.13
+	k8s.io/client-go v0.16.13
+	k8s.io/klog v1.0.0
 )
@@ -8,34 +8,32 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/json"
-	"k8s.io/client-go/informers"
-	// "k8s.io/client-go/kubernetes"
-	// // appsv1listers "k8s.io/client-go/listers/apps/v1"
-	// "k8s.io/client-go/rest"
 
-	"github.com/trilogy-group/k8s-webhooks/pkg/utils"
 	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
-	// "github.com/trilogy-group/k8s-webhooks/pkg/webhooks/server"
 )
 
+const handlerName string = "dynamic-deployment-affinity"
+
 var (
 	minimumReplicasForAffinity int = 3
 	weightForAZAffinity        int = 100
 )
 
-func LoadWebhookPlugin(ws webhooks.WebhookServer) {
-	f := ws.GetFactory("kubernets")
-	if f == nil {
-		f = informers.NewSharedInformerFactory(utils.GetClientsetFromConfigOrDie(utils.GetClientConfigOrDie), 0)
+// Setup registers Admit at the path, it's the default symbol looked up by
+// the plugins loader
+func Setup(ws webhooks.WebhookServer, path string) {
+	if err := ws.RegisterHandler(path, webhooks.V1beta1Handler(Admit),
+		webhooks.WithHandlerName(handlerName)); err != nil {
+		klog.Errorf("Can't register %s: %v", handlerName, err)
 	}
 }
 
-func getWeightedPodAffinityTerms() (ret []corev1.WeightedPodAffinityTerm) {
+func getWeightedPodAffinityTerms(labels map[string]string) (ret []corev1.WeightedPodAffinityTerm) {
 	ret = append(ret, corev1.WeightedPodAffinityTerm{
-		Weight: weightForAZAffinity,
+		Weight: int32(weightForAZAffinity),
 		PodAffinityTerm: corev1.PodAffinityTerm{
 			LabelSelector: &metav1.LabelSelector{
-				MatchLabels: depl.Spec.Template.ObjectMeta.Labels,
+				MatchLabels: labels,
 			},
 			TopologyKey: "failure-domain.beta.kubernetes.io/zone",
 		},
@@ -64,7 +62,7 @@ func Admit(ar *admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResp
 	}
 
 	// check if replicas is >= 3 and there is no affinity in Spec
-	if *depl.Spec.Replicas < 3 {
+	if depl.Spec.Replicas == nil || *depl.Spec.Replicas < int32(minimumReplicasForAffinity) {
 		// leave it unchanged
 		return &admissionV1beta1.AdmissionResponse{Allowed: true}
 	}
@@ -76,7 +74,7 @@ func Admit(ar *admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResp
 	// prepare the patch adding affinity podAntiAffinity by AZs
 	depl.Spec.Template.Spec.Affinity = &corev1.Affinity{
 		PodAntiAffinity: &corev1.PodAntiAffinity{
-			PreferredDuringSchedulingIgnoredDuringExecution: getWeightedPodAffinityTerms(),
+			PreferredDuringSchedulingIgnoredDuringExecution: getWeightedPodAffinityTerms(depl.Spec.Template.ObjectMeta.Labels),
 		},
 	}
 
@@ -107,3 +105,6 @@ func Admit(ar *admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResp
 		}(),
 	}
 }
+
+// main is never called, the package is built with -buildmode=plugin
+func main() {}
@@ -0,0 +1,227 @@
+package dynamicpluglins
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	admissionV1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/klog"
+
+	"github.com/trilogy-group/k8s-webhooks/pkg/rpcplugins"
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+const (
+	// HandlersFile is the handlers YAML looked up in the plugins directory
+	HandlersFile string = "handlers.yaml"
+	// SetupSymbol is the symbol used when the handler doesn't name one
+	SetupSymbol string = "Setup"
+
+	pluginExt string = ".so"
+)
+
+// ErrGoPluginsNotSupported is the error of the plugins directory when it holds
+// Go plugins but the server is built without cgo, e.g. the static binary. The
+// server logs it and runs without them.
+var ErrGoPluginsNotSupported = errors.New("Go plugins (.so) need a server built with cgo (CGO_ENABLED=1), " +
+	"use an out of process plugin or the image built by make docker-plugins")
+
+// LoadPlugins opens the Go plugins (.so) of the directory, and registers the
+// handlers at the paths given in the handlers YAML (read from HandlersFile in
+// the directory when empty). The handler symbol of a plugin can be:
+//   - a func(webhooks.WebhookServer, string), like WebhookHandler.Setup
+//   - a webhooks.WebhookHandler variable
+//   - a webhooks.AdmissionHandler or a webhooks.V1beta1AdmissionHandler, func
+//     or variable
+//
+// The handlers naming an executable or a socket are forwarded to out of
+// process plugins (see rpcplugins), which run until stopCh is closed.
+//
+// Errors are returned by path, or by file for the plugins that can't be
+// opened, a failing plugin doesn't prevent the others from loading. When the
+// server can't open Go plugins at all, only the out of process plugins are
+// loaded and the error of the directory is ErrGoPluginsNotSupported.
+func LoadPlugins(ws webhooks.WebhookServer, dir, handlersYAML string, stopCh <-chan struct{}) map[string]error {
+	errs := make(map[string]error)
+	files, err := pluginFiles(dir)
+	if err != nil {
+		errs[dir] = err
+		return errs
+	}
+
+	if handlersYAML == "" {
+		data, err := ioutil.ReadFile(filepath.Join(dir, HandlersFile))
+		if err != nil && !os.IsNotExist(err) {
+			errs[dir] = err
+			return errs
+		}
+		handlersYAML = string(data)
+	}
+	handlersMap, err := webhooks.ParseHandlersMap(handlersYAML)
+	if err != nil {
+		errs[dir] = fmt.Errorf("invalid handlers YAML: %v", err)
+		return errs
+	}
+
+	if len(files) > 0 && !goPluginsSupported {
+		errs[dir] = ErrGoPluginsNotSupported
+		files = nil
+	}
+	plugins := make(map[string]*plugin.Plugin)
+	for _, file := range files {
+		p, err := plugin.Open(filepath.Join(dir, file))
+		if err != nil {
+			errs[file] = err
+			continue
+		}
+		plugins[file] = p
+	}
+
+	used := make(map[string]bool)
+	external := make(map[string]*rpcplugins.Plugin)
+	paths := make([]string, 0, len(handlersMap))
+	for path := range handlersMap {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		ph := handlersMap[path]
+		if ph.Socket != "" || !strings.HasSuffix(ph.Filename, pluginExt) {
+			if err := setupExternalPlugin(ws, dir, path, ph, external, stopCh); err != nil {
+				errs[path] = err
+			}
+			continue
+		}
+		used[ph.Filename] = true
+		if !goPluginsSupported {
+			continue
+		}
+		if _, failed := errs[ph.Filename]; failed {
+			errs[path] = fmt.Errorf("plugin %s failed to open", ph.Filename)
+			continue
+		}
+		p, found := plugins[ph.Filename]
+		if !found {
+			errs[path] = fmt.Errorf("plugin %s not found in %s", ph.Filename, dir)
+			continue
+		}
+		if err := setupPlugin(ws, path, ph, p); err != nil {
+			errs[path] = err
+			continue
+		}
+		klog.Infof("Loaded plugin %s at path %s", ph.Filename, path)
+	}
+
+	for _, file := range files {
+		if !used[file] {
+			klog.Warningf("Plugin %s isn't used by any handler of %s", file, HandlersFile)
+		}
+	}
+	return errs
+}
+
+// pluginFiles lists the .so files of the directory, a missing directory has
+// no plugins
+func pluginFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), pluginExt) {
+			files = append(files, info.Name())
+		}
+	}
+	return files, nil
+}
+
+func setupPlugin(ws webhooks.WebhookServer, path string, ph webhooks.PluggedHandler, p *plugin.Plugin) (err error) {
+	symbolName := ph.HandlerFuncName
+	if symbolName == "" {
+		symbolName = SetupSymbol
+	}
+	symbol, err := p.Lookup(symbolName)
+	if err != nil {
+		return err
+	}
+
+	// a broken plugin mustn't take the server down
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin %s panicked: %v", ph.Filename, r)
+		}
+	}()
+
+	var opts []webhooks.HandlerOption
+	if ph.Name != "" {
+		opts = append(opts, webhooks.WithHandlerName(ph.Name))
+	}
+	switch s := symbol.(type) {
+	case func(webhooks.WebhookServer, string):
+		s(ws, path)
+	case *webhooks.WebhookHandler:
+		(*s).Setup(ws, path)
+	case func(*webhooks.AdmissionReview) *admissionV1.AdmissionResponse:
+		return ws.RegisterHandler(path, s, opts...)
+	case *webhooks.AdmissionHandler:
+		return ws.RegisterHandler(path, *s, opts...)
+	case func(*admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse:
+		return ws.RegisterHandler(path, webhooks.V1beta1Handler(s), opts...)
+	case *webhooks.V1beta1AdmissionHandler:
+		return ws.RegisterHandler(path, webhooks.V1beta1Handler(*s), opts...)
+	default:
+		return fmt.Errorf("symbol %s of plugin %s has unsupported type %T", symbolName, ph.Filename, symbol)
+	}
+	return nil
+}
+
+// setupExternalPlugin registers the handler forwarding the requests to the out
+// of process plugin, started once whatever the number of paths it serves
+func setupExternalPlugin(ws webhooks.WebhookServer, dir, path string, ph webhooks.PluggedHandler,
+	external map[string]*rpcplugins.Plugin, stopCh <-chan struct{}) error {
+	key := ph.Socket
+	if key == "" {
+		key = strings.Join(append([]string{ph.Filename}, ph.Args...), " ")
+	}
+	p, running := external[key]
+	if !running {
+		if ph.Socket != "" {
+			p = rpcplugins.NewSocketPlugin(ph.Socket, ph.Socket)
+		} else {
+			file := filepath.Join(dir, ph.Filename)
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || info.Mode()&0111 == 0 {
+				return fmt.Errorf("plugin %s is not executable", ph.Filename)
+			}
+			p = rpcplugins.NewCommandPlugin(ph.Filename, file, ph.Args...)
+		}
+	}
+
+	name := ph.Name
+	if name == "" {
+		name = p.Name()
+	}
+	if err := ws.RegisterHandler(path, p.Handler(), webhooks.WithHandlerName(name)); err != nil {
+		return err
+	}
+	if !running {
+		external[key] = p
+		go p.Run(stopCh)
+	}
+	klog.Infof("Forwarding path %s to plugin %s", path, p.Name())
+	return nil
+}
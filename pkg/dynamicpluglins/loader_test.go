@@ -0,0 +1,71 @@
+package dynamicpluglins
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPluginsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.so"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin either"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := LoadPlugins(nil, filepath.Join(dir, "missing"), "", nil); len(errs) != 0 {
+		t.Errorf("LoadPlugins() of a missing directory = %v, want no error", errs)
+	}
+
+	errs := LoadPlugins(nil, dir, "/broken:\n  filename: broken.so\n/missing:\n  filename: missing.so\n", nil)
+	if !goPluginsSupported {
+		if len(errs) != 1 || errs[dir] != ErrGoPluginsNotSupported {
+			t.Errorf("LoadPlugins() without cgo = %v, want only %v", errs, ErrGoPluginsNotSupported)
+		}
+		return
+	}
+	for _, name := range []string{"broken.so", "/broken", "/missing"} {
+		if errs[name] == nil {
+			t.Errorf("LoadPlugins() has no error for %s: %v", name, errs)
+		}
+	}
+	if len(errs) != 3 {
+		t.Errorf("LoadPlugins() = %v, want errors for broken.so, /broken and /missing", errs)
+	}
+
+	errs = LoadPlugins(nil, dir, "/broken: [", nil)
+	if len(errs) != 1 || errs[dir] == nil {
+		t.Errorf("LoadPlugins() of an invalid handlers YAML = %v, want the error of the directory", errs)
+	}
+}
+
+func TestPluginFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"b.so", "a.so", HandlersFile} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "c.so"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := pluginFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != "a.so" || files[1] != "b.so" {
+		t.Errorf("pluginFiles() = %v, want [a.so b.so]", files)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build (linux && cgo) || (darwin && cgo) || (freebsd && cgo)
+// +build linux,cgo darwin,cgo freebsd,cgo
+
+package dynamicpluglins
+
+// the Go plugins are opened with dlopen, which needs cgo
+const goPluginsSupported bool = true
@@ -0,0 +1,7 @@
+//go:build (!linux && !darwin && !freebsd) || !cgo
+// +build !linux,!darwin,!freebsd !cgo
+
+package dynamicpluglins
+
+// without cgo (e.g. the static binary) plugin.Open always fails
+const goPluginsSupported bool = false
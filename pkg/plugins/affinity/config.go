@@ -0,0 +1,63 @@
+package affinity
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+const configMapKey string = "deploymentAffinity"
+
+// legacyKeys are the keys of the ConfigMap the settings were read from before
+// the deploymentAffinity section, still used when the section is missing
+var legacyKeys = []string{"minimumReplicasForAffinity", "weightForAffinity", "topologyKeyForAffinity"}
+
+// Config is the deploymentAffinity section of the ConfigMap
+type Config struct {
+	MinimumReplicasForAffinity int    `yaml:"minimumReplicasForAffinity"`
+	WeightForAffinity          int    `yaml:"weightForAffinity"`
+	TopologyKeyForAffinity     string `yaml:"topologyKeyForAffinity"`
+}
+
+func (c *Config) Validate() error {
+	if c.MinimumReplicasForAffinity < 1 {
+		return errors.New(fmt.Sprintf("minimumReplicasForAffinity %d is less than 1", c.MinimumReplicasForAffinity))
+	}
+	// the range of the weight of a preferred scheduling term
+	if c.WeightForAffinity < 1 || c.WeightForAffinity > 100 {
+		return errors.New(fmt.Sprintf("weightForAffinity %d is not in the range 1-100", c.WeightForAffinity))
+	}
+	if c.TopologyKeyForAffinity == "" {
+		return errors.New("topologyKeyForAffinity is empty")
+	}
+	return nil
+}
+
+var configSection = webhooks.NewConfigSection(configMapKey, func() webhooks.PluginConfig {
+	return &Config{
+		MinimumReplicasForAffinity: defaultMinimumReplicasForAffinity,
+		WeightForAffinity:          defaultWeightForAffinity,
+		TopologyKeyForAffinity:     defaultTopologyKey,
+	}
+}).WithFallback(decodeLegacyKeys)
+
+func currentConfig() *Config {
+	return configSection.Current().(*Config)
+}
+
+// decodeLegacyKeys decodes the legacy keys as if they were the fields of the
+// section, so their values are checked the same way
+func decodeLegacyKeys(data map[string]string, cfg webhooks.PluginConfig) error {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range legacyKeys {
+		if val, found := data[key]; found {
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+				&yaml.Node{Kind: yaml.ScalarNode, Value: val})
+		}
+	}
+	return node.Decode(cfg)
+}
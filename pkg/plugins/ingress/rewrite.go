@@ -3,15 +3,11 @@ package ingress
 import (
 	"strings"
 
-	"k8s.io/klog"
-
-	admissionV1beta1 "k8s.io/api/admission/v1beta1"
 	extensionsV1beta1 "k8s.io/api/extensions/v1beta1"
-	// corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks/manager"
 )
 
 type webhookHandler struct{}
@@ -20,49 +16,49 @@ func NewWebhookHandler() webhooks.WebhookHandler {
 	return &webhookHandler{}
 }
 
+func init() {
+	manager.Register(manager.Plugin{
+		Name:        handlerName,
+		Description: "Sets the nginx rewrite-target annotation of the Ingresses",
+		DefaultPath: "/ingress/rewrite",
+		New:         NewWebhookHandler,
+	})
+}
+
 func (wh *webhookHandler) Setup(server webhooks.WebhookServer, path string) {
-	server.RegisterHandler(path, mutateIngressRewriteTarget)
+	server.RegisterHandler(path, webhooks.MutateObjectInto(newIngress, mutateIngressRewriteTarget),
+		webhooks.WithHandlerName(handlerName))
 }
 
 const (
-	rewriteTargetAnnotKey = "nginx.ingress.kubernetes.io/rewrite-target"
+	handlerName string = "ingress-rewrite-target"
+
+	rewriteTargetAnnotKey string = "nginx.ingress.kubernetes.io/rewrite-target"
 )
 
-func mutateIngressRewriteTarget(ar *admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse {
-	var patch []webhooks.PatchOperation
-	var value interface{}
-	var ing extensionsV1beta1.Ingress
+func newIngress() runtime.Object { return &extensionsV1beta1.Ingress{} }
 
-	if err := json.Unmarshal(ar.Request.Object.Raw, &ing); err != nil {
-		klog.Errorf("Could not unmarshal raw object: %v", err)
-		return &admissionV1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-	}
+func mutateIngressRewriteTarget(ar *webhooks.AdmissionReview, obj runtime.Object) error {
+	ing := obj.(*extensionsV1beta1.Ingress)
 
-	// core logic
 	v, hasRewrite := ing.ObjectMeta.Annotations[rewriteTargetAnnotKey]
 	if !hasRewrite {
 		// leave it unchanged
-		return &admissionV1beta1.AdmissionResponse{Allowed: true}
+		return nil
 	}
 	if strings.Contains(v, "$") { // not very robust
 		// already has regex group reference
 		// leave it unchanged
-		return &admissionV1beta1.AdmissionResponse{Allowed: true}
+		return nil
 	}
 
 	needsDelete := true
 	for _, r := range ing.Spec.Rules {
 		if r.IngressRuleValue.HTTP == nil {
-			needsDelete = needsDelete && true
 			continue
 		}
 		for ip, p := range r.IngressRuleValue.HTTP.Paths {
 			if p.Path == v && v == "/" {
-				needsDelete = needsDelete && true
 				continue
 			}
 			needsDelete = false
@@ -76,52 +72,11 @@ func mutateIngressRewriteTarget(ar *admissionV1beta1.AdmissionReview) *admission
 
 	if needsDelete {
 		delete(ing.ObjectMeta.Annotations, rewriteTargetAnnotKey)
-		value = ing.ObjectMeta.Annotations
-		patch = append(patch, webhooks.PatchOperation{
-			Op:    "replace",
-			Path:  "/metadata/annotations",
-			Value: value,
-		})
-	} else {
-		// needs patch
-		if !strings.HasSuffix(v, "/") {
-			v = v + "/"
-		}
-		v = v + "$1"
-		ing.ObjectMeta.Annotations[rewriteTargetAnnotKey] = v
-		value = ing.ObjectMeta.Annotations
-		patch = append(patch, webhooks.PatchOperation{
-			Op:    "replace",
-			Path:  "/metadata/annotations",
-			Value: value,
-		})
-
-		value = ing.Spec.Rules
-		patch = append(patch, webhooks.PatchOperation{
-			Op:    "replace",
-			Path:  "/spec/rules",
-			Value: value,
-		})
-	}
-	// end core logic
-
-	patchBytes, err := json.Marshal(patch)
-	if err != nil {
-		klog.Errorf("Could not marshal patch: %v", patch)
-		return &admissionV1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
+		return nil
 	}
-
-	klog.Infof("AdmissionResponse: patch=%v\n", string(patchBytes))
-	return &admissionV1beta1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
-		PatchType: func() *admissionV1beta1.PatchType {
-			pt := admissionV1beta1.PatchTypeJSONPatch
-			return &pt
-		}(),
+	if !strings.HasSuffix(v, "/") {
+		v = v + "/"
 	}
+	ing.ObjectMeta.Annotations[rewriteTargetAnnotKey] = v + "$1"
+	return nil
 }
@@ -3,27 +3,22 @@ package jivewebappaffinity
 import (
 	"fmt"
 	"strings"
-	"time"
 
 	"k8s.io/klog"
 
-	admissionV1beta1 "k8s.io/api/admission/v1beta1"
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/util/json"
-	"k8s.io/client-go/informers"
-	"k8s.io/client-go/kubernetes"
 	l_autoscalingv1 "k8s.io/client-go/listers/autoscaling/v1"
 	l_corev1 "k8s.io/client-go/listers/core/v1"
-	"k8s.io/client-go/tools/cache"
 
-	"github.com/trilogy-group/k8s-webhooks/pkg/utils"
 	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks/manager"
 )
 
 const (
+	handlerName string = "jive-webapps-affinity"
+
 	configMapKey string = "jiveWebAppsAffinity"
 
 	defaultMaximumHpaReplicas  int    = 10
@@ -39,16 +34,6 @@ const (
 )
 
 var (
-	maximumHpaReplicas  int    = defaultMaximumHpaReplicas
-	hpaName             string = defaultHpaName
-	podLabelForAffinity string = defaultPodLabelForAffinity
-	topologyKey         string = defaultTopologyKey
-	nsLabelSelStr       string = defaultNsLabelSelStr
-	hpaLabelSelStr      string = defaultHpaLabelSelStr
-	nsPrefix            string = defaultNsPrefix
-
-	nsLabelSel, hpaLabelSel labels.Selector
-
 	nsLister  l_corev1.NamespaceLister
 	hpaLister l_autoscalingv1.HorizontalPodAutoscalerLister
 )
@@ -59,74 +44,52 @@ func NewWebhookHandler() webhooks.WebhookHandler {
 	return &webhookHandler{}
 }
 
-func (wh *webhookHandler) Setup(server webhooks.WebhookServer, path string) {
-	var cs kubernetes.Interface
-	var err error
+func init() {
+	manager.Register(manager.Plugin{
+		Name:        handlerName,
+		Description: "Spreads the pods of the Jive webapps across the nodes",
+		DefaultPath: "/jive/webapp",
+		New:         NewWebhookHandler,
+		Config:      configSection,
+	})
+}
 
-	config := server.GetConfig()
+func (wh *webhookHandler) Setup(server webhooks.WebhookServer, path string) {
+	// the label selectors can change with the ConfigMap, so the namespaces
+	// and the HPAs are watched cluster-wide
+	f, err := server.ScopedFactory(webhooks.FactoryScope{})
+	if err != nil {
+		klog.Errorf("Can't setup %s: %v", handlerName, err)
+		return
+	}
 
 	// Dynamic configuration management
-	f := server.GetFactory("kubernetes")
-	if f == nil {
-		if cs == nil {
-			cs = utils.GetClientsetOrDie(config.Kubeconfig, nil)
-		}
-		// get initial values from CM
-		if cm, err := cs.CoreV1().ConfigMaps(config.CmNamespace).
-			Get(config.CmName, metav1.GetOptions{}); err == nil {
-			if _, ok := cm.Data[configMapKey]; ok {
-				setVarsFromYAMLString(cm.Data[configMapKey])
-			}
-		}
-		f := informers.NewSharedInformerFactory(cs, 300*time.Second)
-		server.RegisterFactory("kubernetes", f)
+	if err := webhooks.WatchConfigSection(server, configSection); err != nil {
+		klog.Errorf("Can't setup %s: %v", handlerName, err)
+		return
 	}
-	f.Core().V1().ConfigMaps().Informer().AddEventHandler(
-		cache.FilteringResourceEventHandler{
-			FilterFunc: func(obj interface{}) bool {
-				cm, ok := obj.(*corev1.ConfigMap)
-				if ok &&
-					cm.ObjectMeta.Namespace == config.CmNamespace &&
-					cm.ObjectMeta.Name == config.CmName {
-					return true
-				}
-				return false
-			},
-			Handler: cache.ResourceEventHandlerFuncs{
-				AddFunc: func(obj interface{}) {
-					onConfigMapUpdate(nil, obj)
-				},
-				UpdateFunc: onConfigMapUpdate,
-			},
-		})
 
 	nsLister = f.Core().V1().Namespaces().Lister()
 	hpaLister = f.Autoscaling().V1().HorizontalPodAutoscalers().Lister()
 
-	// setup label selector for NSa and HPAs
-	if nsLabelSel, err = labels.Parse(nsLabelSelStr); err != nil {
-		klog.Fatalf("Invalid NS labels string: %s: %+v", nsLabelSelStr, err)
-	}
-	if hpaLabelSel, err = labels.Parse(hpaLabelSelStr); err != nil {
-		klog.Fatalf("Invalid NS labels string: %s: %+v", hpaLabelSelStr, err)
-	}
-
-	server.RegisterHandler(path, mutateAffinity)
+	server.RegisterHandler(path, webhooks.MutatePodTemplate(mutateAffinity),
+		webhooks.WithHandlerName(handlerName),
+		webhooks.WithMatch(webhooks.PodTemplateMatch()))
 }
 
-func getHardPodAntiAffinityTerm(labels map[string]string) corev1.PodAffinityTerm {
+func getHardPodAntiAffinityTerm(cfg *Config, labels map[string]string) corev1.PodAffinityTerm {
 	return corev1.PodAffinityTerm{
 		LabelSelector: &metav1.LabelSelector{
 			MatchLabels: labels,
 		},
-		TopologyKey: topologyKey,
+		TopologyKey: cfg.TopologyKey,
 	}
 }
 
-func isExistingPodAntiAffinityOk(terms []corev1.PodAffinityTerm) bool {
+func isExistingPodAntiAffinityOk(cfg *Config, terms []corev1.PodAffinityTerm) bool {
 	for _, term := range terms {
 		if term.LabelSelector != nil {
-			if _, ok := term.LabelSelector.MatchLabels[podLabelForAffinity]; ok {
+			if _, ok := term.LabelSelector.MatchLabels[cfg.PodLabelForAffinity]; ok {
 				return true
 			}
 		}
@@ -135,23 +98,22 @@ func isExistingPodAntiAffinityOk(terms []corev1.PodAffinityTerm) bool {
 }
 
 // this is the implementation of the core logic
-// the params can be from pod or deployment.spec.template
+// the params can be from a pod or any pod template
 // this is getting pointers to be able to modify the structures as side-effect
 // and fill with the rigth pod anti-affinity
 // When this returning non-nil error means that the modification cannot be done, so
 // the webhook should leave the obcjec unchanged.
-// the return value in case of success is the first patch to the affinity attribute Op and Value,
-// basically the "add" or "replcace" and the updated Affinity attribute
-func checkAndUpdateAffinity(namespace string, metadata *metav1.ObjectMeta, spec *corev1.PodSpec) (string, interface{}, error) {
+func checkAndUpdateAffinity(namespace string, metadata *metav1.ObjectMeta, spec *corev1.PodSpec) error {
 	klog.V(5).Infof("checkAndUpdateAffinity (in ns %s) on metadata: %+v -- spec: %+v", namespace, metadata, spec)
+	cfg := currentConfig()
 	{
-		if list, err := nsLister.List(nsLabelSel); err != nil {
+		if list, err := nsLister.List(cfg.nsLabelSel); err != nil {
 			klog.V(6).Infof("Listing cached NSs err: %+v", err)
 		} else {
 			klog.V(6).Infof("Listing cached NSs: %+v", list)
 		}
 
-		if list, err := hpaLister.List(hpaLabelSel); err != nil {
+		if list, err := hpaLister.List(cfg.hpaLabelSel); err != nil {
 			klog.V(6).Infof("Listing cached HPAs err: %+v", err)
 		} else {
 			klog.V(6).Infof("Listing cached HPAs: %+v", list)
@@ -159,212 +121,73 @@ func checkAndUpdateAffinity(namespace string, metadata *metav1.ObjectMeta, spec
 	}
 
 	// check for namespace prefix if we have to
-	if len(nsPrefix) > 0 && !strings.HasPrefix(namespace, nsPrefix) {
-		return "", nil, fmt.Errorf("Namespace %s has not prefix %s", namespace, nsPrefix)
+	if len(cfg.NsPrefix) > 0 && !strings.HasPrefix(namespace, cfg.NsPrefix) {
+		return fmt.Errorf("Namespace %s has not prefix %s", namespace, cfg.NsPrefix)
 	}
 
 	// check for the label we want to use in pod anti-affinity
-	if _, ok := metadata.Labels[podLabelForAffinity]; !ok {
-		return "", nil, fmt.Errorf("Failed retrieving %s label on %s/%s",
-			podLabelForAffinity, namespace, metadata.Name)
+	if _, ok := metadata.Labels[cfg.PodLabelForAffinity]; !ok {
+		return fmt.Errorf("Failed retrieving %s label on %s/%s",
+			cfg.PodLabelForAffinity, namespace, metadata.Name)
 	}
 	labelsForAffinity := make(map[string]string)
-	labelsForAffinity[podLabelForAffinity] = metadata.Labels[podLabelForAffinity]
+	labelsForAffinity[cfg.PodLabelForAffinity] = metadata.Labels[cfg.PodLabelForAffinity]
 
 	// check if the Namespace is a jive jcx installation one
 	ns, err := nsLister.Get(namespace)
 	if err != nil {
-		return "", nil, fmt.Errorf("Failed retrieving %s: %+v", namespace, err)
+		return fmt.Errorf("Failed retrieving %s: %+v", namespace, err)
 	}
 
-	if !nsLabelSel.Matches(labels.Set(ns.ObjectMeta.Labels)) {
+	if !cfg.nsLabelSel.Matches(labels.Set(ns.ObjectMeta.Labels)) {
 		// leave it unchanged
-		return "", nil, fmt.Errorf("Namespace %s doesn't match labels", namespace)
+		return fmt.Errorf("Namespace %s doesn't match labels", namespace)
 	}
 
 	// try to get the WebApp HPA in this NS
-	hpa, err := hpaLister.HorizontalPodAutoscalers(ns.ObjectMeta.Name).Get(hpaName)
+	hpa, err := hpaLister.HorizontalPodAutoscalers(ns.ObjectMeta.Name).Get(cfg.HpaName)
 	if err != nil {
 		// leave it unchanged
-		return "", nil, fmt.Errorf("Failed retrieving %s/%s: %+v", ns.ObjectMeta.Name, hpaName, err)
+		return fmt.Errorf("Failed retrieving %s/%s: %+v", ns.ObjectMeta.Name, cfg.HpaName, err)
 	}
 
-	if !hpaLabelSel.Matches(labels.Set(hpa.ObjectMeta.Labels)) {
+	if !cfg.hpaLabelSel.Matches(labels.Set(hpa.ObjectMeta.Labels)) {
 		// leave it unchanged
-		return "", nil, fmt.Errorf("HPA does't match labels")
+		return fmt.Errorf("HPA does't match labels")
 	}
 
 	// check if maxReplicas in this HPA is ok to set affinity
-	if hpa.Spec.MaxReplicas > int32(maximumHpaReplicas) {
+	if hpa.Spec.MaxReplicas > int32(cfg.MaximumHpaReplicas) {
 		// leave it unchanged
-		return "", nil, fmt.Errorf("too much HPA maxReplicas")
+		return fmt.Errorf("too much HPA maxReplicas")
 	}
 
-	affinityPatchOp := "replace"
 	if spec.Affinity == nil {
 		spec.Affinity = &corev1.Affinity{}
-		affinityPatchOp = "add"
 	}
 
 	if spec.Affinity.PodAntiAffinity == nil {
 		spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
-	} else if isExistingPodAntiAffinityOk(
+	} else if isExistingPodAntiAffinityOk(cfg,
 		spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) {
 		// leave it unchanged
-		return "", nil, fmt.Errorf("No need to patch")
+		return fmt.Errorf("No need to patch")
 	}
 
 	terms := spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
-	terms = append(terms, getHardPodAntiAffinityTerm(labelsForAffinity))
+	terms = append(terms, getHardPodAntiAffinityTerm(cfg, labelsForAffinity))
 	spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = terms
 
-	return affinityPatchOp, spec.Affinity, nil
-
-}
-
-func mutateAffinity(ar *admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse {
-	switch ar.Request.Kind.Kind {
-	case "Deployment":
-		return mutateDeploymentAffinity(ar)
-	case "Pod":
-		return mutatePodAffinity(ar)
-	}
-	return &admissionV1beta1.AdmissionResponse{Allowed: true}
-}
-
-func mutateDeploymentAffinity(ar *admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse {
-	var patch []webhooks.PatchOperation
-	var value interface{}
-	var depl appsv1.Deployment
-
-	if err := json.Unmarshal(ar.Request.Object.Raw, &depl); err != nil {
-		klog.Errorf("Could not unmarshal raw object: %v", err)
-		return &admissionV1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-	}
-
-	// core logic
-	affinityPatchOp, value, err := checkAndUpdateAffinity(
-		ar.Request.Namespace,
-		&depl.Spec.Template.ObjectMeta,
-		&depl.Spec.Template.Spec)
-
-	if err != nil {
-		klog.Errorf("%v", err)
-		// leave it unchanged
-		return &admissionV1beta1.AdmissionResponse{Allowed: true}
-	}
-
-	patch = append(patch, webhooks.PatchOperation{
-		Op:    affinityPatchOp,
-		Path:  "/spec/template/spec/affinity",
-		Value: value,
-	})
-
-	annotValue := "Deployment Affinity updated to spread across Nodes"
-	if depl.Annotations == nil {
-		patch = append(patch, webhooks.PatchOperation{
-			Op:    "add",
-			Path:  "/metadata/annotations",
-			Value: map[string]string{"mutatingWebookAffinity": annotValue},
-		})
-	} else {
-		patch = append(patch, webhooks.PatchOperation{
-			Op:    "add",
-			Path:  "/metadata/annotations/mutatingWebookAffinity",
-			Value: annotValue,
-		})
-	}
-
-	// end core logic
-
-	patchBytes, err := json.Marshal(patch)
-	if err != nil {
-		klog.Errorf("Could not marshal patch: %v", patch)
-		return &admissionV1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-	}
-
-	klog.Infof("AdmissionResponse: patch=%v\n", string(patchBytes))
-	return &admissionV1beta1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
-		PatchType: func() *admissionV1beta1.PatchType {
-			pt := admissionV1beta1.PatchTypeJSONPatch
-			return &pt
-		}(),
-	}
+	return nil
 }
 
-func mutatePodAffinity(ar *admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse {
-	var patch []webhooks.PatchOperation
-	var value interface{}
-	var pod corev1.Pod
-
-	if err := json.Unmarshal(ar.Request.Object.Raw, &pod); err != nil {
-		klog.Errorf("Could not unmarshal raw object: %v", err)
-		return &admissionV1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-	}
-
-	// core logic
-
-	affinityPatchOp, value, err := checkAndUpdateAffinity(ar.Request.Namespace, &pod.ObjectMeta, &pod.Spec)
-	if err != nil {
+func mutateAffinity(ar *webhooks.AdmissionReview, pt *webhooks.PodTemplate) error {
+	if err := checkAndUpdateAffinity(ar.Request.Namespace, pt.Metadata, pt.Spec); err != nil {
 		klog.Errorf("%v", err)
 		// leave it unchanged
-		return &admissionV1beta1.AdmissionResponse{Allowed: true}
-	}
-
-	patch = append(patch, webhooks.PatchOperation{
-		Op:    affinityPatchOp,
-		Path:  "/spec/affinity",
-		Value: value,
-	})
-
-	annotValue := "Pod Affinity updated to spread across AZs"
-	if pod.Annotations == nil {
-		patch = append(patch, webhooks.PatchOperation{
-			Op:    "add",
-			Path:  "/metadata/annotations",
-			Value: map[string]string{"mutatingWebookAffinity": annotValue},
-		})
-	} else {
-		patch = append(patch, webhooks.PatchOperation{
-			Op:    "add",
-			Path:  "/metadata/annotations/mutatingWebookAffinity",
-			Value: annotValue,
-		})
-	}
-
-	// end core logic
-
-	patchBytes, err := json.Marshal(patch)
-	if err != nil {
-		klog.Errorf("Could not marshal patch: %v", patch)
-		return &admissionV1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-	}
-
-	klog.Infof("AdmissionResponse: patch=%v\n", string(patchBytes))
-	return &admissionV1beta1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
-		PatchType: func() *admissionV1beta1.PatchType {
-			pt := admissionV1beta1.PatchTypeJSONPatch
-			return &pt
-		}(),
+		return nil
 	}
+	metav1.SetMetaDataAnnotation(pt.Object, "mutatingWebookAffinity",
+		fmt.Sprintf("%s Affinity updated to spread across Nodes", pt.Kind))
+	return nil
 }
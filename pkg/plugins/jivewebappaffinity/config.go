@@ -0,0 +1,92 @@
+package jivewebappaffinity
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// Config is the jiveWebAppsAffinity section of the ConfigMap
+type Config struct {
+	MaximumHpaReplicas  int    `yaml:"maximumHpaReplicas"`
+	HpaName             string `yaml:"hpaName"`
+	PodLabelForAffinity string `yaml:"podLabelForAffinity"`
+	TopologyKey         string `yaml:"topologyKey"`
+	NsLabelSelStr       string `yaml:"nsLabelSelStr"`
+	HpaLabelSelStr      string `yaml:"hpaLabelSelStr"`
+	NsPrefix            string `yaml:"nsPrefix"`
+
+	nsLabelSel, hpaLabelSel labels.Selector
+}
+
+// Validate checks the values and parses the label selectors
+func (c *Config) Validate() error {
+	if c.MaximumHpaReplicas < 1 {
+		return errors.New(fmt.Sprintf("maximumHpaReplicas %d is less than 1", c.MaximumHpaReplicas))
+	}
+	// in the order of the fields, so the first empty one is reported
+	for _, field := range []struct{ name, val string }{
+		{"hpaName", c.HpaName},
+		{"podLabelForAffinity", c.PodLabelForAffinity},
+		{"topologyKey", c.TopologyKey},
+	} {
+		if field.val == "" {
+			return errors.New(fmt.Sprintf("%s is empty", field.name))
+		}
+	}
+	var err error
+	if c.nsLabelSel, err = labels.Parse(c.NsLabelSelStr); err != nil {
+		return errors.New(fmt.Sprintf("invalid nsLabelSelStr %q: %v", c.NsLabelSelStr, err))
+	}
+	if c.hpaLabelSel, err = labels.Parse(c.HpaLabelSelStr); err != nil {
+		return errors.New(fmt.Sprintf("invalid hpaLabelSelStr %q: %v", c.HpaLabelSelStr, err))
+	}
+	return nil
+}
+
+var configSection = webhooks.NewConfigSection(configMapKey, func() webhooks.PluginConfig {
+	return &Config{
+		MaximumHpaReplicas:  defaultMaximumHpaReplicas,
+		HpaName:             defaultHpaName,
+		PodLabelForAffinity: defaultPodLabelForAffinity,
+		TopologyKey:         defaultTopologyKey,
+		NsLabelSelStr:       defaultNsLabelSelStr,
+		HpaLabelSelStr:      defaultHpaLabelSelStr,
+		NsPrefix:            defaultNsPrefix,
+	}
+}).WithLegacyFormat(decodeLegacyFormat)
+
+func currentConfig() *Config {
+	return configSection.Current().(*Config)
+}
+
+// decodeLegacyFormat decodes the section as it was read before being decoded
+// strictly: maximumHpaReplicas can be a quoted number and the unknown fields
+// are ignored
+func decodeLegacyFormat(data string, cfg webhooks.PluginConfig) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	fields := doc.Content[0]
+	if fields.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(fields.Content); i += 2 {
+			key, val := fields.Content[i], fields.Content[i+1]
+			if key.Value != "maximumHpaReplicas" || val.Kind != yaml.ScalarNode {
+				continue
+			}
+			if _, err := strconv.Atoi(val.Value); err == nil {
+				val.Tag, val.Style = "!!int", 0
+			}
+		}
+	}
+	return doc.Decode(cfg)
+}
@@ -0,0 +1,39 @@
+package jivewebappaffinity
+
+import (
+	"testing"
+)
+
+func TestConfigSectionLegacyFormat(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantReplicas int
+		wantPrefix   string
+		wantErr      bool
+	}{
+		{name: "strict", data: "maximumHpaReplicas: 5\nnsPrefix: jcx-\n", wantReplicas: 5, wantPrefix: "jcx-"},
+		{name: "quoted number", data: "maximumHpaReplicas: \"7\"\nnsPrefix: jcx-\n", wantReplicas: 7, wantPrefix: "jcx-"},
+		{name: "unknown field", data: "maximumHpaReplicas: '8'\nformerField: x\n", wantReplicas: 8},
+		{name: "quoted word", data: "maximumHpaReplicas: \"many\"\n", wantReplicas: defaultMaximumHpaReplicas, wantErr: true},
+		{name: "invalid quoted value", data: "maximumHpaReplicas: \"0\"\n", wantReplicas: defaultMaximumHpaReplicas, wantErr: true},
+		{name: "invalid selector", data: "maximumHpaReplicas: \"3\"\nnsLabelSelStr: \"a in (b\"\n", wantReplicas: defaultMaximumHpaReplicas, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := configSection.Update(""); err != nil {
+				t.Fatal(err)
+			}
+			if err := configSection.Update(tt.data); (err != nil) != tt.wantErr {
+				t.Fatalf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			cfg := currentConfig()
+			if cfg.MaximumHpaReplicas != tt.wantReplicas || cfg.NsPrefix != tt.wantPrefix {
+				t.Errorf("config = %+v, want maximumHpaReplicas %d and nsPrefix %q", cfg, tt.wantReplicas, tt.wantPrefix)
+			}
+			if cfg.nsLabelSel == nil || cfg.hpaLabelSel == nil {
+				t.Error("label selectors not parsed")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package rpcplugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// conn sends the requests of the manager to a plugin, matching the responses
+// to the requests by ID so the requests can run concurrently
+type conn struct {
+	rwc io.ReadWriteCloser
+
+	writeLock sync.Mutex
+	encoder   *json.Encoder
+
+	lock    sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *Message
+
+	// closed once nothing can be read anymore, err tells why
+	done chan struct{}
+	err  error
+}
+
+func newConn(rwc io.ReadWriteCloser) *conn {
+	c := &conn{
+		rwc:     rwc,
+		encoder: json.NewEncoder(rwc),
+		pending: make(map[uint64]chan *Message),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *conn) readLoop() {
+	decoder := json.NewDecoder(c.rwc)
+	for {
+		msg := &Message{}
+		if err := decoder.Decode(msg); err != nil {
+			if err == io.EOF {
+				err = errors.New("connection closed by the plugin")
+			}
+			c.err = err
+			close(c.done)
+			return
+		}
+		c.lock.Lock()
+		ch, found := c.pending[msg.ID]
+		delete(c.pending, msg.ID)
+		c.lock.Unlock()
+		if !found {
+			klog.Warningf("Dropping response %d of plugin, the request is gone", msg.ID)
+			continue
+		}
+		ch <- msg
+	}
+}
+
+// call sends the request and waits for its response
+func (c *conn) call(method string, review *Review, timeout time.Duration) (*Message, error) {
+	// buffered, so a late response doesn't block the read loop
+	ch := make(chan *Message, 1)
+	c.lock.Lock()
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+	}()
+
+	c.writeLock.Lock()
+	err := c.encoder.Encode(&Message{Version: ProtocolVersion, ID: id, Method: method, Review: review})
+	c.writeLock.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("can't send %s request: %v", method, err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-ch:
+		if msg.Error != "" {
+			return nil, errors.New(msg.Error)
+		}
+		return msg, nil
+	case <-c.done:
+		return nil, c.err
+	case <-timer.C:
+		return nil, fmt.Errorf("no answer to %s request in %v", method, timeout)
+	}
+}
+
+func (c *conn) close() error {
+	return c.rwc.Close()
+}
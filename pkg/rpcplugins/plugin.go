@@ -0,0 +1,244 @@
+package rpcplugins
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/klog"
+
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+const (
+	handshakeTimeout  time.Duration = 10 * time.Second
+	healthInterval    time.Duration = 10 * time.Second
+	healthTimeout     time.Duration = 2 * time.Second
+	callTimeout       time.Duration = 30 * time.Second // longest webhook timeout of the API server
+	stopGracePeriod   time.Duration = 5 * time.Second
+	dialTimeout       time.Duration = 5 * time.Second
+	minRestartBackoff time.Duration = time.Second
+	maxRestartBackoff time.Duration = time.Minute
+)
+
+// dialer opens a connection to the plugin, along with the function releasing
+// what was started for it
+type dialer func() (io.ReadWriteCloser, func(), error)
+
+// Plugin is a plugin running out of the manager process, either an executable
+// spawned by the manager and spoken to over its stdin and stdout, or a server
+// listening on a Unix socket. The plugin is (re)started by Run, health checked
+// every 10s, and restarted with an exponential backoff when it fails.
+type Plugin struct {
+	name string
+	dial dialer
+
+	lock sync.RWMutex
+	conn *conn
+	stop func()
+}
+
+// NewCommandPlugin returns the plugin spawning the executable with the given
+// arguments. The stderr of the plugin goes to the one of the manager.
+func NewCommandPlugin(name, path string, args ...string) *Plugin {
+	return &Plugin{name: name, dial: commandDialer(name, path, args)}
+}
+
+// NewSocketPlugin returns the plugin listening on the Unix socket
+func NewSocketPlugin(name, socket string) *Plugin {
+	return &Plugin{
+		name: name,
+		dial: func() (io.ReadWriteCloser, func(), error) {
+			c, err := net.DialTimeout("unix", socket, dialTimeout)
+			return c, func() {}, err
+		},
+	}
+}
+
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Healthy tells whether the plugin is connected and passed its last health check
+func (p *Plugin) Healthy() bool {
+	return p.current() != nil
+}
+
+// Handler forwards the requests to the plugin. When the plugin isn't running
+// or doesn't answer, the handler returns no response, so the failure policy
+// of the handler applies.
+func (p *Plugin) Handler() webhooks.AdmissionHandler {
+	return func(ar *webhooks.AdmissionReview) *admissionV1.AdmissionResponse {
+		c := p.current()
+		if c == nil {
+			klog.Errorf("Plugin %s is not running, can't review %s (uid: %s)", p.name, ar.Path, ar.Request.UID)
+			return nil
+		}
+		reply, err := c.call(MethodAdmit, &Review{
+			APIVersion: ar.APIVersion,
+			Path:       ar.Path,
+			Handler:    ar.Handler,
+			Request:    ar.Request,
+		}, callTimeout)
+		if err != nil {
+			klog.Errorf("Plugin %s failed to review %s (uid: %s): %v", p.name, ar.Path, ar.Request.UID, err)
+			return nil
+		}
+		if reply.Response == nil {
+			klog.Errorf("Plugin %s returned no response for %s (uid: %s)", p.name, ar.Path, ar.Request.UID)
+		}
+		return reply.Response
+	}
+}
+
+// Run starts the plugin and keeps it running until stopCh is closed
+func (p *Plugin) Run(stopCh <-chan struct{}) {
+	backoff := minRestartBackoff
+	for {
+		started := time.Now()
+		c, err := p.start()
+		if err != nil {
+			klog.Errorf("Can't start plugin %s: %v", p.name, err)
+		} else {
+			klog.Infof("Plugin %s started", p.name)
+			p.watch(c, stopCh)
+			p.shutdown()
+			// a plugin that ran for a while starts over with the shortest backoff
+			if time.Since(started) > maxRestartBackoff {
+				backoff = minRestartBackoff
+			}
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(backoff):
+		}
+		klog.Infof("Restarting plugin %s", p.name)
+		if backoff *= 2; backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
+
+// start connects to the plugin and checks it speaks our protocol version
+func (p *Plugin) start() (*conn, error) {
+	rwc, stop, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(rwc)
+	reply, err := c.call(MethodHandshake, nil, handshakeTimeout)
+	if err == nil && reply.Version != ProtocolVersion {
+		err = fmt.Errorf("plugin speaks protocol %s, expect %s", reply.Version, ProtocolVersion)
+	}
+	if err != nil {
+		c.close()
+		stop()
+		return nil, fmt.Errorf("handshake failed: %v", err)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.conn = c
+	p.stop = stop
+	return c, nil
+}
+
+// watch returns when the plugin goes away, fails a health check, or stopCh
+// is closed
+func (p *Plugin) watch(c *conn, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-c.done:
+			klog.Errorf("Lost plugin %s: %v", p.name, c.err)
+			return
+		case <-ticker.C:
+			if _, err := c.call(MethodHealth, nil, healthTimeout); err != nil {
+				klog.Errorf("Plugin %s failed its health check: %v", p.name, err)
+				return
+			}
+		}
+	}
+}
+
+func (p *Plugin) shutdown() {
+	p.lock.Lock()
+	c, stop := p.conn, p.stop
+	p.conn, p.stop = nil, nil
+	p.lock.Unlock()
+	if c == nil {
+		return
+	}
+	c.close()
+	stop()
+}
+
+func (p *Plugin) current() *conn {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.conn
+}
+
+// pipes is the connection to a spawned plugin, reading its stdout and
+// writing its stdin
+type pipes struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (p *pipes) Close() error {
+	err := p.WriteCloser.Close()
+	if rerr := p.ReadCloser.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func commandDialer(name, path string, args []string) dialer {
+	return func() (io.ReadWriteCloser, func(), error) {
+		cmd := exec.Command(path, args...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+
+		// the plugin is expected to exit once its stdin is closed, it's
+		// killed if it doesn't
+		stop := func() {
+			exited := make(chan error, 1)
+			go func() {
+				exited <- cmd.Wait()
+			}()
+			var err error
+			select {
+			case err = <-exited:
+			case <-time.After(stopGracePeriod):
+				klog.Warningf("Plugin %s didn't exit in %v, killing it", name, stopGracePeriod)
+				cmd.Process.Kill()
+				err = <-exited
+			}
+			if err != nil {
+				klog.Errorf("Plugin %s exited: %v", name, err)
+			}
+		}
+		return &pipes{ReadCloser: stdout, WriteCloser: stdin}, stop, nil
+	}
+}
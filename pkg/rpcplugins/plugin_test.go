@@ -0,0 +1,152 @@
+package rpcplugins
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// echo admits the requests, after waiting the milliseconds given by their UID
+func echo(ar *webhooks.AdmissionReview) *admissionV1.AdmissionResponse {
+	if ms, err := strconv.Atoi(string(ar.Request.UID)); err == nil {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+	}
+	if ar.Request.UID == "panic" {
+		panic("boom")
+	}
+	return &admissionV1.AdmissionResponse{UID: ar.Request.UID, Allowed: true,
+		AuditAnnotations: map[string]string{"path": ar.Path, "handler": ar.Handler}}
+}
+
+func admitReview(uid string) *Review {
+	return &Review{APIVersion: "admission.k8s.io/v1", Path: "/mutate", Handler: "h",
+		Request: &admissionV1.AdmissionRequest{UID: types.UID(uid)}}
+}
+
+func TestAnswer(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     *Message
+		wantErr bool
+	}{
+		{name: "handshake", msg: &Message{Version: ProtocolVersion, ID: 1, Method: MethodHandshake}},
+		{name: "health", msg: &Message{Version: ProtocolVersion, ID: 2, Method: MethodHealth}},
+		{name: "admit", msg: &Message{Version: ProtocolVersion, ID: 3, Method: MethodAdmit, Review: admitReview("uid")}},
+		{name: "other version", msg: &Message{Version: "v2", ID: 4, Method: MethodHandshake}, wantErr: true},
+		{name: "unknown method", msg: &Message{Version: ProtocolVersion, ID: 5, Method: "mutate"}, wantErr: true},
+		{name: "admit without request", msg: &Message{Version: ProtocolVersion, ID: 6, Method: MethodAdmit}, wantErr: true},
+		{name: "handler panic", msg: &Message{Version: ProtocolVersion, ID: 7, Method: MethodAdmit, Review: admitReview("panic")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := answer(tt.msg, echo)
+			if reply.ID != tt.msg.ID || reply.Version != ProtocolVersion {
+				t.Errorf("reply = %+v, want id %d version %s", reply, tt.msg.ID, ProtocolVersion)
+			}
+			if (reply.Error != "") != tt.wantErr {
+				t.Errorf("reply error = %q, wantErr %v", reply.Error, tt.wantErr)
+			}
+			if tt.wantErr && reply.Response != nil {
+				t.Errorf("reply response = %+v, want none with the error", reply.Response)
+			}
+			if tt.msg.Method == MethodAdmit && !tt.wantErr &&
+				(reply.Response == nil || reply.Response.AuditAnnotations["path"] != "/mutate") {
+				t.Errorf("reply response = %+v, want the answer of the handler", reply.Response)
+			}
+		})
+	}
+}
+
+func TestConnConcurrentCalls(t *testing.T) {
+	manager, plugin := net.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeConn(plugin, echo)
+	}()
+	c := newConn(manager)
+
+	if _, err := c.call(MethodHandshake, nil, time.Second); err != nil {
+		t.Fatalf("handshake error = %v", err)
+	}
+	// the slowest request is sent first, the responses come in any order
+	var wg sync.WaitGroup
+	for _, uid := range []string{"50", "25", "0"} {
+		wg.Add(1)
+		go func(uid string) {
+			defer wg.Done()
+			reply, err := c.call(MethodAdmit, admitReview(uid), time.Second)
+			if err != nil {
+				t.Errorf("call %s error = %v", uid, err)
+				return
+			}
+			if reply.Response == nil || string(reply.Response.UID) != uid {
+				t.Errorf("call %s got response %+v", uid, reply.Response)
+			}
+		}(uid)
+	}
+	wg.Wait()
+
+	if _, err := c.call(MethodAdmit, admitReview("panic"), time.Second); err == nil {
+		t.Error("call of a panicking handler should fail")
+	}
+	if _, err := c.call(MethodAdmit, admitReview("100"), 10*time.Millisecond); err == nil {
+		t.Error("call answered after its timeout should fail")
+	}
+
+	c.close()
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("connection not done once closed")
+	}
+	if _, err := c.call(MethodHealth, nil, time.Second); err == nil {
+		t.Error("call on a closed connection should fail")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ServeConn() error = %v", err)
+	}
+}
+
+func TestSocketPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpcplugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "plugin.sock")
+
+	p := NewSocketPlugin("socket-plugin", socket)
+	review := &webhooks.AdmissionReview{APIVersion: "admission.k8s.io/v1", Path: "/mutate", Handler: "h",
+		Request: &admissionV1.AdmissionRequest{UID: "uid"}}
+	if resp := p.Handler()(review); resp != nil || p.Healthy() {
+		t.Fatalf("plugin not running answered %+v", resp)
+	}
+
+	go ServeSocket(socket, echo)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go p.Run(stopCh)
+
+	// the plugin can start before the socket is listening, it's retried
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("plugin not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp := p.Handler()(review)
+	if resp == nil || !resp.Allowed || resp.UID != "uid" || resp.AuditAnnotations["handler"] != "h" {
+		t.Errorf("response = %+v, want the answer of the plugin", resp)
+	}
+}
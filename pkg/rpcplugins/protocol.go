@@ -0,0 +1,44 @@
+package rpcplugins
+
+import (
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+// ProtocolVersion is the version of the protocol spoken with the plugins, the
+// manager refuses the plugins answering the handshake with another one
+const ProtocolVersion string = "v1"
+
+const (
+	// MethodHandshake is sent first on every connection
+	MethodHandshake string = "handshake"
+	// MethodHealth is sent periodically, a plugin not answering in time or
+	// answering with an error is restarted
+	MethodHealth string = "health"
+	// MethodAdmit forwards an AdmissionReview to the plugin
+	MethodAdmit string = "admit"
+)
+
+// Message is a request or a response of the protocol, written as one JSON
+// document per line. A response carries the ID of its request, and the
+// responses can come in any order. A request fails when its response has an
+// Error.
+type Message struct {
+	Version string `json:"version"`
+	ID      uint64 `json:"id"`
+
+	// requests
+	Method string  `json:"method,omitempty"`
+	Review *Review `json:"review,omitempty"`
+
+	// responses
+	Response *admissionV1.AdmissionResponse `json:"response,omitempty"`
+	Error    string                         `json:"error,omitempty"`
+}
+
+// Review is the AdmissionReview forwarded to the plugin
+type Review struct {
+	APIVersion string                        `json:"apiVersion"`
+	Path       string                        `json:"path"`
+	Handler    string                        `json:"handler"`
+	Request    *admissionV1.AdmissionRequest `json:"request"`
+}
@@ -0,0 +1,124 @@
+package rpcplugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"k8s.io/klog"
+
+	"github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// stdio is the connection of a plugin spawned by the manager
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+func (stdio) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdio) Close() error {
+	return os.Stdin.Close()
+}
+
+// Serve answers the requests of the manager on stdin and stdout until stdin
+// is closed, it's what the executables spawned by the manager call from their
+// main. Anything else written to stdout breaks the protocol: logs have to go
+// to stderr, which is klog's default.
+func Serve(h webhooks.AdmissionHandler) error {
+	return ServeConn(stdio{}, h)
+}
+
+// ServeSocket answers the requests of the managers connecting to the Unix
+// socket, replacing the socket file left by a previous run
+func ServeSocket(socket string, h webhooks.AdmissionHandler) error {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := ServeConn(c, h); err != nil {
+				klog.Errorf("Connection of the manager failed: %v", err)
+			}
+		}()
+	}
+}
+
+// ServeConn answers the requests read from the connection until it's closed,
+// the requests are handled concurrently
+func ServeConn(rwc io.ReadWriteCloser, h webhooks.AdmissionHandler) error {
+	defer rwc.Close()
+	decoder := json.NewDecoder(rwc)
+	encoder := json.NewEncoder(rwc)
+	var writeLock sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		msg := &Message{}
+		if err := decoder.Decode(msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := answer(msg, h)
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			if err := encoder.Encode(reply); err != nil {
+				klog.Errorf("Can't send response %d: %v", reply.ID, err)
+			}
+		}()
+	}
+}
+
+func answer(msg *Message, h webhooks.AdmissionHandler) (reply *Message) {
+	reply = &Message{Version: ProtocolVersion, ID: msg.ID}
+	if msg.Version != ProtocolVersion {
+		reply.Error = fmt.Sprintf("unsupported protocol version %q, expect %s", msg.Version, ProtocolVersion)
+		return reply
+	}
+	switch msg.Method {
+	case MethodHandshake, MethodHealth:
+	case MethodAdmit:
+		if msg.Review == nil || msg.Review.Request == nil {
+			reply.Error = "admit request without AdmissionRequest"
+			return reply
+		}
+		// a panic fails the request, not the plugin
+		defer func() {
+			if r := recover(); r != nil {
+				klog.Errorf("Handler panicked reviewing %s (uid: %s): %v", msg.Review.Path, msg.Review.Request.UID, r)
+				reply.Response = nil
+				reply.Error = fmt.Sprintf("handler panicked: %v", r)
+			}
+		}()
+		reply.Response = h(&webhooks.AdmissionReview{
+			APIVersion: msg.Review.APIVersion,
+			Request:    msg.Review.Request,
+			Path:       msg.Review.Path,
+			Handler:    msg.Review.Handler,
+		})
+	default:
+		reply.Error = fmt.Sprintf("unknown method %q", msg.Method)
+	}
+	return reply
+}
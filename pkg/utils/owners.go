@@ -0,0 +1,267 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// UIDIndex is the name of the index built by GetObjectUIDIndexFunc
+	UIDIndex string = "uid"
+
+	ownerPollInterval time.Duration = 100 * time.Millisecond
+	maxOwnerChain     int           = 10
+)
+
+// the owner kinds known by the OwnerResolver
+var (
+	ReplicaSetKind            = schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}
+	DeploymentKind            = schema.GroupKind{Group: "apps", Kind: "Deployment"}
+	StatefulSetKind           = schema.GroupKind{Group: "apps", Kind: "StatefulSet"}
+	DaemonSetKind             = schema.GroupKind{Group: "apps", Kind: "DaemonSet"}
+	JobKind                   = schema.GroupKind{Group: "batch", Kind: "Job"}
+	CronJobKind               = schema.GroupKind{Group: "batch", Kind: "CronJob"}
+	ReplicationControllerKind = schema.GroupKind{Group: "", Kind: "ReplicationController"}
+)
+
+// ownerKind tells how to find the objects of a kind that can own pods
+type ownerKind struct {
+	informer func(informers.SharedInformerFactory) cache.SharedIndexInformer
+	get      func(cs kubernetes.Interface, namespace, name string) (metav1.Object, error)
+}
+
+var (
+	replicaSetKind = ownerKind{
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Apps().V1().ReplicaSets().Informer()
+		},
+		get: func(cs kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			return cs.AppsV1().ReplicaSets(namespace).Get(name, metav1.GetOptions{})
+		},
+	}
+	deploymentKind = ownerKind{
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Apps().V1().Deployments().Informer()
+		},
+		get: func(cs kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			return cs.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+		},
+	}
+	statefulSetKind = ownerKind{
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Apps().V1().StatefulSets().Informer()
+		},
+		get: func(cs kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			return cs.AppsV1().StatefulSets(namespace).Get(name, metav1.GetOptions{})
+		},
+	}
+	daemonSetKind = ownerKind{
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Apps().V1().DaemonSets().Informer()
+		},
+		get: func(cs kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			return cs.AppsV1().DaemonSets(namespace).Get(name, metav1.GetOptions{})
+		},
+	}
+	jobKind = ownerKind{
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Batch().V1().Jobs().Informer()
+		},
+		get: func(cs kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			return cs.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+		},
+	}
+	cronJobKind = ownerKind{
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Batch().V1beta1().CronJobs().Informer()
+		},
+		get: func(cs kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			return cs.BatchV1beta1().CronJobs(namespace).Get(name, metav1.GetOptions{})
+		},
+	}
+	replicationControllerKind = ownerKind{
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Core().V1().ReplicationControllers().Informer()
+		},
+		get: func(cs kubernetes.Interface, namespace, name string) (metav1.Object, error) {
+			return cs.CoreV1().ReplicationControllers(namespace).Get(name, metav1.GetOptions{})
+		},
+	}
+
+	ownerKinds = map[schema.GroupKind]ownerKind{
+		ReplicaSetKind:            replicaSetKind,
+		DeploymentKind:            deploymentKind,
+		StatefulSetKind:           statefulSetKind,
+		DaemonSetKind:             daemonSetKind,
+		JobKind:                   jobKind,
+		CronJobKind:               cronJobKind,
+		ReplicationControllerKind: replicationControllerKind,
+	}
+
+	// the owner references can still use the old API groups
+	legacyOwnerKinds = map[schema.GroupKind]schema.GroupKind{
+		{Group: "extensions", Kind: "ReplicaSet"}: ReplicaSetKind,
+		{Group: "extensions", Kind: "Deployment"}: DeploymentKind,
+		{Group: "extensions", Kind: "DaemonSet"}:  DaemonSetKind,
+	}
+)
+
+// Owner is a link of an owner chain. Object is nil when the kind of the owner
+// isn't one the resolver knows about (e.g. a custom resource).
+type Owner struct {
+	Reference metav1.OwnerReference
+	Object    metav1.Object
+}
+
+// OwnerResolver follows the controller owner references of the objects up to
+// the top-level workload, looking the owners up by UID in the informers
+// caches. Owners missing from the caches (e.g. a pod admitted before its
+// ReplicaSet is seen) are waited for up to the given duration, then looked up
+// through the API server if a client is given.
+type OwnerResolver struct {
+	indexers map[schema.GroupKind]cache.Indexer
+	// kinds whose informer was started before the uid index could be added
+	unindexed map[schema.GroupKind]bool
+	client    kubernetes.Interface
+	wait      time.Duration
+}
+
+// NewOwnerResolver adds the uid indexers to the informers of the given owner
+// kinds in the factory. Informers already started can't get new indexers, the
+// owners of those kinds are then found by scanning their caches. The chains
+// stop at the owners of the other kinds, returned without Object.
+func NewOwnerResolver(f informers.SharedInformerFactory, client kubernetes.Interface, cacheWait time.Duration,
+	kinds ...schema.GroupKind) *OwnerResolver {
+	r := &OwnerResolver{
+		indexers:  make(map[schema.GroupKind]cache.Indexer),
+		unindexed: make(map[schema.GroupKind]bool),
+		client:    client,
+		wait:      cacheWait,
+	}
+	for _, gk := range kinds {
+		kind, known := ownerKinds[gk]
+		if !known {
+			klog.Errorf("Unknown owner kind %s", gk)
+			continue
+		}
+		informer := kind.informer(f)
+		if _, found := informer.GetIndexer().GetIndexers()[UIDIndex]; !found {
+			if err := informer.AddIndexers(cache.Indexers{UIDIndex: GetObjectUIDIndexFunc()}); err != nil {
+				klog.Warningf("Can't add the %s index to %s informer, scanning its cache instead: %v",
+					UIDIndex, gk, err)
+				r.unindexed[gk] = true
+			}
+		}
+		r.indexers[gk] = informer.GetIndexer()
+	}
+	return r
+}
+
+// OwnerChain returns the controllers of the object, from its direct owner to
+// the top-level one, empty when the object has no controller
+func (r *OwnerResolver) OwnerChain(obj metav1.Object) ([]Owner, error) {
+	var chain []Owner
+	namespace := obj.GetNamespace()
+	for current := obj; current != nil; {
+		ref := metav1.GetControllerOf(current)
+		if ref == nil {
+			break
+		}
+		if len(chain) == maxOwnerChain {
+			return chain, fmt.Errorf("owner chain of %s/%s longer than %d", namespace, obj.GetName(), maxOwnerChain)
+		}
+		owner, err := r.getOwner(namespace, ref)
+		if err != nil {
+			return chain, err
+		}
+		chain = append(chain, Owner{Reference: *ref, Object: owner})
+		current = owner
+	}
+	return chain, nil
+}
+
+// TopLevelOwner returns the last controller of the owner chain of the object,
+// nil when the object has no controller
+func (r *OwnerResolver) TopLevelOwner(obj metav1.Object) (*Owner, error) {
+	chain, err := r.OwnerChain(obj)
+	if err != nil || len(chain) == 0 {
+		return nil, err
+	}
+	return &chain[len(chain)-1], nil
+}
+
+func (r *OwnerResolver) getOwner(namespace string, ref *metav1.OwnerReference) (metav1.Object, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid owner reference %s %s: %v", ref.Kind, ref.Name, err)
+	}
+	gk := schema.GroupKind{Group: gv.Group, Kind: ref.Kind}
+	if current, legacy := legacyOwnerKinds[gk]; legacy {
+		gk = current
+	}
+	indexer, known := r.indexers[gk]
+	if !known {
+		return nil, nil
+	}
+
+	var owner metav1.Object
+	lookup := func() (bool, error) {
+		objs, err := r.byUID(gk, indexer, string(ref.UID))
+		if err != nil || len(objs) != 1 {
+			return false, err
+		}
+		cached, ok := objs[0].(metav1.Object)
+		if !ok {
+			return false, fmt.Errorf("cached %s %s has no meta", gk, ref.Name)
+		}
+		owner = cached
+		return true, nil
+	}
+	found, err := lookup()
+	if err == nil && !found && r.wait > 0 {
+		if err = wait.PollImmediate(ownerPollInterval, r.wait, lookup); err == wait.ErrWaitTimeout {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil {
+		return owner, nil
+	}
+
+	if r.client == nil {
+		return nil, fmt.Errorf("%s %s/%s not found in cache", gk, namespace, ref.Name)
+	}
+	klog.V(2).Infof("%s %s/%s not found in cache, getting it from the API server", gk, namespace, ref.Name)
+	owner, err = ownerKinds[gk].get(r.client, namespace, ref.Name)
+	if err != nil {
+		return nil, fmt.Errorf("can't get %s %s/%s: %v", gk, namespace, ref.Name, err)
+	}
+	if owner.GetUID() != ref.UID {
+		return nil, fmt.Errorf("%s %s/%s has uid %s, expect %s", gk, namespace, ref.Name, owner.GetUID(), ref.UID)
+	}
+	return owner, nil
+}
+
+// byUID returns the cached objects of the kind with the uid
+func (r *OwnerResolver) byUID(gk schema.GroupKind, indexer cache.Indexer, uid string) ([]interface{}, error) {
+	if !r.unindexed[gk] {
+		return indexer.ByIndex(UIDIndex, uid)
+	}
+	var objs []interface{}
+	for _, obj := range indexer.List() {
+		if meta, ok := obj.(metav1.Object); ok && string(meta.GetUID()) == uid {
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
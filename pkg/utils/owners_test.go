@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func controllerRef(kind, name string, uid types.UID) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, UID: uid, Controller: &controller}}
+}
+
+func ownedPod() (*corev1.Pod, []runtime.Object) {
+	depl := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web", UID: "depl-uid"}}
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web-5d4f", UID: "rs-uid",
+		OwnerReferences: controllerRef("Deployment", "web", "depl-uid")}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web-5d4f-x2z", UID: "pod-uid",
+		OwnerReferences: controllerRef("ReplicaSet", "web-5d4f", "rs-uid")}}
+	return pod, []runtime.Object{depl, rs}
+}
+
+func TestOwnerResolver(t *testing.T) {
+	tests := []struct {
+		name          string
+		startedBefore bool
+	}{
+		{name: "indexed", startedBefore: false},
+		{name: "factory already started", startedBefore: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod, owners := ownedPod()
+			f := informers.NewSharedInformerFactory(fake.NewSimpleClientset(owners...), 0)
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+
+			var r *OwnerResolver
+			if tt.startedBefore {
+				f.Apps().V1().ReplicaSets().Informer()
+				f.Apps().V1().Deployments().Informer()
+				f.Start(stopCh)
+				f.WaitForCacheSync(stopCh)
+				r = NewOwnerResolver(f, nil, 0, ReplicaSetKind, DeploymentKind)
+			} else {
+				r = NewOwnerResolver(f, nil, 0, ReplicaSetKind, DeploymentKind)
+				f.Start(stopCh)
+				f.WaitForCacheSync(stopCh)
+			}
+			if r.unindexed[ReplicaSetKind] != tt.startedBefore {
+				t.Errorf("ReplicaSets scanned = %v, want %v", r.unindexed[ReplicaSetKind], tt.startedBefore)
+			}
+
+			chain, err := r.OwnerChain(pod)
+			if err != nil {
+				t.Fatalf("OwnerChain() error = %v", err)
+			}
+			if len(chain) != 2 || chain[0].Object == nil || chain[0].Object.GetUID() != "rs-uid" {
+				t.Fatalf("OwnerChain() = %+v, want the ReplicaSet then the Deployment", chain)
+			}
+			if _, ok := chain[1].Object.(*appsv1.Deployment); !ok || chain[1].Object.GetUID() != "depl-uid" {
+				t.Errorf("top-level owner = %+v, want the Deployment", chain[1])
+			}
+		})
+	}
+}
+
+func TestOwnerResolverFallbacks(t *testing.T) {
+	pod, owners := ownedPod()
+	// the informers see nothing, the client has the owners
+	f := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	withoutClient := NewOwnerResolver(f, nil, 0, ReplicaSetKind)
+	withClient := NewOwnerResolver(f, fake.NewSimpleClientset(owners...), 0, ReplicaSetKind)
+	f.Start(stopCh)
+	f.WaitForCacheSync(stopCh)
+
+	if _, err := withoutClient.TopLevelOwner(pod); err == nil {
+		t.Error("TopLevelOwner() of an owner missing from the cache should fail without client")
+	}
+	// the Deployment kind isn't watched, the chain stops there
+	owner, err := withClient.TopLevelOwner(pod)
+	if err != nil {
+		t.Fatalf("TopLevelOwner() error = %v", err)
+	}
+	if owner.Reference.Kind != "Deployment" || owner.Object != nil {
+		t.Errorf("TopLevelOwner() = %+v, want the Deployment reference without object", owner)
+	}
+	if owner, err := withClient.TopLevelOwner(&corev1.Pod{}); owner != nil || err != nil {
+		t.Errorf("TopLevelOwner() of a pod without controller = %+v, %v", owner, err)
+	}
+}
@@ -42,7 +42,10 @@ func GetClientsetFromConfigOrDie(config *rest.Config) *kubernetes.Clientset {
 	return kubernetes.NewForConfigOrDie(config)
 }
 
-func GetClientset(kubeconfig string, overrides *clientcmd.ConfigOverrides) (kubernetes.Interface, error) {
+// GetRestConfig loads the kubeconfig with the overrides (e.g. the context),
+// the in-cluster config when no kubeconfig is given and running in a Pod,
+// the kubeconfig of the home directory otherwise
+func GetRestConfig(kubeconfig string, overrides *clientcmd.ConfigOverrides) (*rest.Config, error) {
 	var config *rest.Config
 	var err error
 	if kubeconfig == "" {
@@ -54,12 +57,19 @@ func GetClientset(kubeconfig string, overrides *clientcmd.ConfigOverrides) (kube
 	}
 
 	if err == nil && config == nil {
+		if overrides == nil {
+			overrides = &clientcmd.ConfigOverrides{}
+		}
 		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
 			overrides,
 		).ClientConfig()
 	}
+	return config, err
+}
 
+func GetClientset(kubeconfig string, overrides *clientcmd.ConfigOverrides) (kubernetes.Interface, error) {
+	config, err := GetRestConfig(kubeconfig, overrides)
 	if err != nil {
 		return nil, err
 	}
@@ -2,8 +2,13 @@ package webhooks
 
 import (
 	// "flag"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/spf13/pflag"
-	// "k8s.io/client-go/tools/clientcmd"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
@@ -12,12 +17,25 @@ const (
 	defaultConfigMapName      string = "webhooks-manager-config"
 	defaultKubeconfig         string = "" // clientcmd.RecommendedConfigPathFlag
 
-	defaultPluginsDir string = "/webhookplugins"
-	defaultAdmit      string = "Always"
+	defaultKubeAPIQPS   float32 = 20
+	defaultKubeAPIBurst int     = 30
+	defaultUserAgent    string  = "webhooks-manager"
+
+	defaultPluginsDir     string        = "/webhookplugins"
+	defaultAdmit          string        = "Always"
+	defaultHandlerTimeout time.Duration = 8 * time.Second
+	defaultMaxBodyBytes   int64         = 7 * 1024 * 1024
 
 	defaultPort     int    = 443
 	defaultCertFile string = "/etc/webhook/certs/cert.pem"
 	defaultKeyFile  string = "/etc/webhook/certs/key.pem"
+
+	defaultMetricsPort int = 8080
+
+	defaultSelfSignedCerts      bool   = false
+	defaultCertsSecretNamespace string = "kube-system"
+	defaultCertsSecretName      string = "webhooks-manager-certs"
+	defaultServiceName          string = "webhooks-manager"
 )
 
 // TODO: drop WhSrvFlags and WhSrvParameters, use directly WebhookServerConfig
@@ -27,67 +45,182 @@ type WhSrvFlags struct {
 	CertFile string `json:"certFile"`
 	KeyFile  string `json:"keyFile"`
 
+	MetricsPort int `json:"metricsPort"`
+
 	UseConfigMap bool   `json:"useConfigMap"`
 	Kubeconfig   string `json:"kubeconfig"`
 	CmNamespace  string `json:"configMapNamespace"`
 	CmName       string `json:"configMapName"`
 
-	PluginsDir         string `json:"pluginsDir"`
-	DefaultAdmitPolicy string `json:"defaultAdmitPolicy"`
+	KubeAPIQPS   float32 `json:"kubeAPIQPS"`
+	KubeAPIBurst int     `json:"kubeAPIBurst"`
+	UserAgent    string  `json:"userAgent"`
+
+	Plugins             []string      `json:"plugins"`
+	PluginsDir          string        `json:"pluginsDir"`
+	DefaultAdmitPolicy  string        `json:"defaultAdmitPolicy"`
+	HandlerTimeout      time.Duration `json:"handlerTimeout"`
+	MaxRequestBodyBytes int64         `json:"maxRequestBodyBytes"`
+
+	SelfSignedCerts                 bool     `json:"selfSignedCerts"`
+	CertsSecretNamespace            string   `json:"certsSecretNamespace"`
+	CertsSecretName                 string   `json:"certsSecretName"`
+	ServiceName                     string   `json:"serviceName"`
+	MutatingWebhookConfigurations   []string `json:"mutatingWebhookConfigurations"`
+	ValidatingWebhookConfigurations []string `json:"validatingWebhookConfigurations"`
 }
 
 func BindFlags(flags *WhSrvFlags, fs *pflag.FlagSet) {
 	fs.IntVar(&flags.Port, "port", defaultPort, "Listen on port. Default: 443")
 	fs.StringVar(&flags.CertFile, "tlsCertFile", defaultCertFile, "File containing the x509 Certificate for HTTPS.")
 	fs.StringVar(&flags.KeyFile, "tlsKeyFile", defaultKeyFile, "File containing the x509 private key to --tlsCertFile.")
+	fs.IntVar(&flags.MetricsPort, "metrics-port", defaultMetricsPort, "Serve Prometheus metrics over HTTP on port, 0 to disable. Default: 8080")
 
 	fs.BoolVar(&flags.UseConfigMap, "use-config-map", defaultUseConfigMap, "Optional absolute path to the kubeconfig file")
 	fs.StringVar(&flags.Kubeconfig, "kubeconfig", defaultKubeconfig, "Optional absolute path to the kubeconfig file")
 	fs.StringVar(&flags.CmNamespace, "config-map-namespace", defaultConfigMapNamespace, "")
 	fs.StringVar(&flags.CmName, "config-map-name", defaultConfigMapName, "")
 
-	fs.StringVar(&flags.PluginsDir, "plugins-dir", defaultPluginsDir, "")
-	fs.StringVar(&flags.DefaultAdmitPolicy, "default-admit-policy", defaultAdmit, "")
+	fs.Float32Var(&flags.KubeAPIQPS, "kube-api-qps", defaultKubeAPIQPS,
+		"Queries per second to the API server, shared by the server and the plugins")
+	fs.IntVar(&flags.KubeAPIBurst, "kube-api-burst", defaultKubeAPIBurst,
+		"Burst of queries to the API server, shared by the server and the plugins")
+	fs.StringVar(&flags.UserAgent, "user-agent", defaultUserAgent, "User agent of the requests to the API server")
+
+	fs.StringSliceVar(&flags.Plugins, "plugins", nil,
+		"Built-in plugins to enable, as name or name=path to serve it on another path than its default one")
+	fs.StringVar(&flags.PluginsDir, "plugins-dir", defaultPluginsDir,
+		"Directory of the Go plugins (.so) to load, with the handlers.yaml mapping the paths to them")
+	fs.StringVar(&flags.DefaultAdmitPolicy, "default-admit-policy", defaultAdmit,
+		"Always or Never: answer to the requests no handler is registered or matching for, and on handler failures")
+	fs.DurationVar(&flags.HandlerTimeout, "handler-timeout", defaultHandlerTimeout,
+		"Deadline for the handlers to answer, after that the handler failure policy applies. 0 to disable")
+	fs.Int64Var(&flags.MaxRequestBodyBytes, "max-request-body-bytes", defaultMaxBodyBytes,
+		"Requests with a bigger body are rejected without calling the handlers, 0 for no limit")
+
+	fs.BoolVar(&flags.SelfSignedCerts, "self-signed-certs", defaultSelfSignedCerts,
+		"Generate a self-signed CA and serving certificate, stored in a Secret, instead of using --tlsCertFile/--tlsKeyFile")
+	fs.StringVar(&flags.CertsSecretNamespace, "certs-secret-namespace", defaultCertsSecretNamespace,
+		"Namespace of the Secret and the Service of the webhook server, with --self-signed-certs")
+	fs.StringVar(&flags.CertsSecretName, "certs-secret-name", defaultCertsSecretName,
+		"Name of the Secret holding the self-signed CA and certificate")
+	fs.StringVar(&flags.ServiceName, "service-name", defaultServiceName,
+		"Name of the Service in front of the webhook server, used for the certificate DNS names")
+	fs.StringSliceVar(&flags.MutatingWebhookConfigurations, "mutating-webhook-configurations", nil,
+		"MutatingWebhookConfigurations to keep the caBundle updated for, with --self-signed-certs")
+	fs.StringSliceVar(&flags.ValidatingWebhookConfigurations, "validating-webhook-configurations", nil,
+		"ValidatingWebhookConfigurations to keep the caBundle updated for, with --self-signed-certs")
 }
 
 type WhSrvParameters struct {
-	Port     int    // webhook server port
-	CertFile string // path to the x509 certificate for https
-	KeyFile  string // path to the x509 private key matching `CertFile`
+	Port        int    // webhook server port
+	CertFile    string // path to the x509 certificate for https
+	KeyFile     string // path to the x509 private key matching `CertFile`
+	MetricsPort int    // metrics server port, 0 to disable it
 }
 
 func NewDefaultWebhookServerParameters() *WhSrvParameters {
 	return &WhSrvParameters{
-		Port:     defaultPort,
-		CertFile: defaultCertFile,
-		KeyFile:  defaultKeyFile,
+		Port:        defaultPort,
+		CertFile:    defaultCertFile,
+		KeyFile:     defaultKeyFile,
+		MetricsPort: defaultMetricsPort,
 	}
 }
 
-type pluggedHandler struct {
-	name            string `yaml:"name"`
-	filename        string `yaml:"filename"`
-	handlerFuncName string `yaml:"handler"`
+// PluggedHandler is a handler loaded from a Go plugin (.so) of the plugins
+// directory, the handlers YAML maps the paths to them:
+//
+//	/deployment/affinity:
+//	  name: deployment-affinity
+//	  filename: affinity.so
+//	  handler: Admit
+//
+// The handler symbol defaults to Setup. A filename without the .so extension
+// is an executable spawned with the given args, and a socket is a plugin
+// already listening on it, both running out of process.
+type PluggedHandler struct {
+	Name            string   `yaml:"name"`
+	Filename        string   `yaml:"filename"`
+	HandlerFuncName string   `yaml:"handler"`
+	Args            []string `yaml:"args"`
+	Socket          string   `yaml:"socket"`
+}
+
+// ParseHandlersMap parses the handlers YAML, keyed by path
+func ParseHandlersMap(handlersMapYAML string) (map[string]PluggedHandler, error) {
+	handlersMap := make(map[string]PluggedHandler)
+	if err := yaml.Unmarshal([]byte(handlersMapYAML), &handlersMap); err != nil {
+		return nil, err
+	}
+	for path, ph := range handlersMap {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("path %s of handler %s doesn't start with /", path, ph.Name)
+		}
+		if (ph.Filename == "") == (ph.Socket == "") {
+			return nil, fmt.Errorf("handler for path %s needs either a filename or a socket", path)
+		}
+	}
+	return handlersMap, nil
 }
 
 type WebhookServerConfig struct {
-	DefaultAdmitPolicy string
-	handlersMapYAML    string
-	handlersMap        map[string]pluggedHandler
+	DefaultAdmitPolicy  string
+	AdmitPolicies       []AdmitPolicyRule // overrides of DefaultAdmitPolicy by path and namespace
+	HandlerTimeout      time.Duration     // default deadline for the handlers
+	MaxRequestBodyBytes int64
+
+	// built-in plugins to enable, as name or name=path
+	Plugins []string
+
+	// Go plugins loaded at startup, HandlersMapYAML defaults to the
+	// handlers.yaml file of PluginsDir
+	PluginsDir      string
+	HandlersMapYAML string
 
 	UseConfigMap bool
 	Kubeconfig   string
 	CmNamespace  string
 	CmName       string
+
+	// settings of the clients shared by the server and the plugins
+	KubeOverrides *clientcmd.ConfigOverrides // e.g. the kubeconfig context
+	KubeAPIQPS    float32
+	KubeAPIBurst  int
+	UserAgent     string
+
+	// self-managed CA and certificate, instead of the files in WhSrvParameters
+	SelfSignedCerts                 bool
+	CertsSecretNamespace            string
+	CertsSecretName                 string
+	ServiceName                     string
+	MutatingWebhookConfigurations   []string
+	ValidatingWebhookConfigurations []string
+}
+
+// Validate checks the admit policies
+func (c *WebhookServerConfig) Validate() error {
+	_, err := NewAdmitPolicies(c.DefaultAdmitPolicy, c.AdmitPolicies)
+	return err
 }
 
 func NewDefaultWebhookServerConfig() *WebhookServerConfig {
 	return &WebhookServerConfig{
-		handlersMapYAML:    `{}`,
-		DefaultAdmitPolicy: defaultAdmit,
-		UseConfigMap:       false,
-		Kubeconfig:         defaultKubeconfig,
-		CmNamespace:        defaultConfigMapNamespace,
-		CmName:             defaultConfigMapName,
+		DefaultAdmitPolicy:  defaultAdmit,
+		HandlerTimeout:      defaultHandlerTimeout,
+		MaxRequestBodyBytes: defaultMaxBodyBytes,
+		PluginsDir:          defaultPluginsDir,
+		UseConfigMap:        false,
+		Kubeconfig:          defaultKubeconfig,
+		CmNamespace:         defaultConfigMapNamespace,
+		CmName:              defaultConfigMapName,
+		KubeAPIQPS:          defaultKubeAPIQPS,
+		KubeAPIBurst:        defaultKubeAPIBurst,
+		UserAgent:           defaultUserAgent,
+
+		SelfSignedCerts:      defaultSelfSignedCerts,
+		CertsSecretNamespace: defaultCertsSecretNamespace,
+		CertsSecretName:      defaultCertsSecretName,
+		ServiceName:          defaultServiceName,
 	}
 }
@@ -0,0 +1,72 @@
+package webhooks
+
+import (
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// FailurePolicy tells how to answer when a handler panics, returns no
+// response or doesn't answer before its deadline
+type FailurePolicy string
+
+const (
+	FailurePolicyAllow FailurePolicy = "Allow"
+	FailurePolicyDeny  FailurePolicy = "Deny"
+	// follow the admit policy of the server for the request
+	FailurePolicyDefault FailurePolicy = "Default"
+)
+
+// HandlerConfig holds the settings of a handler given at registration time
+type HandlerConfig struct {
+	// Name of the handler, used in logs and metrics labels.
+	// Defaults to the name of the handler function.
+	Name string
+	// Timeout is the deadline for the handler to answer, zero means the
+	// HandlerTimeout of the server.
+	Timeout time.Duration
+	// FailurePolicy applied on panic, nil response or timeout, defaults to FailurePolicyDefault.
+	FailurePolicy FailurePolicy
+	// Middlewares wrapping only this handler, inside the server-wide ones.
+	Middlewares []Middleware
+	// Match selects the requests the handler is called for, nil means all
+	// the requests of the path.
+	Match *Match
+}
+
+type HandlerOption func(*HandlerConfig)
+
+func WithHandlerName(name string) HandlerOption {
+	return func(hc *HandlerConfig) {
+		hc.Name = name
+	}
+}
+
+func WithTimeout(timeout time.Duration) HandlerOption {
+	return func(hc *HandlerConfig) {
+		hc.Timeout = timeout
+	}
+}
+
+func WithFailurePolicy(policy FailurePolicy) HandlerOption {
+	return func(hc *HandlerConfig) {
+		hc.FailurePolicy = policy
+	}
+}
+
+func WithHandlerMiddleware(mws ...Middleware) HandlerOption {
+	return func(hc *HandlerConfig) {
+		hc.Middlewares = append(hc.Middlewares, mws...)
+	}
+}
+
+func NewHandlerConfig(h AdmissionHandler, opts ...HandlerOption) *HandlerConfig {
+	hc := &HandlerConfig{FailurePolicy: FailurePolicyDefault}
+	for _, opt := range opts {
+		opt(hc)
+	}
+	if hc.Name == "" && h != nil {
+		hc.Name = runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	}
+	return hc
+}
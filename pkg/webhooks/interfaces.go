@@ -2,12 +2,17 @@ package webhooks
 
 import (
 	"context"
-	admissionV1beta1 "k8s.io/api/admission/v1beta1"
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
 )
 
 type FactoriesMap map[string]informers.SharedInformerFactory
-type AdmissionHandler func(*admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse
+type DynamicFactoriesMap map[string]dynamicinformer.DynamicSharedInformerFactory
+type AdmissionHandler func(*AdmissionReview) *admissionV1.AdmissionResponse
 type HandlersMap map[string]AdmissionHandler
 type WebhookHandler interface {
 	Setup(WebhookServer, string)
@@ -17,18 +22,53 @@ type WebhookServerOption func(WebhookServer) WebhookServer
 type WebhookServer interface {
 	Start() error
 	Shutdown(context.Context) error
-	RegisterHandler(path string, handler AdmissionHandler) error
+	RegisterHandler(path string, handler AdmissionHandler, opts ...HandlerOption) error
+	UnregisterHandler(path, name string) error
+	ReplaceHandler(path string, handler AdmissionHandler, opts ...HandlerOption) error
 	GetHandlerForPath(path string) AdmissionHandler
+	Use(mws ...Middleware)
 	StartFactory(factoryName string) error
+	StartInformers()
 	RegisterFactory(factoryName string, f informers.SharedInformerFactory)
+	GetOrCreateFactory(factoryName string, newFactory func() informers.SharedInformerFactory) informers.SharedInformerFactory
 	GetFactory(factoryName string) informers.SharedInformerFactory
+	// the dynamic factories share the names and the lifecycle of the typed ones
+	RegisterDynamicFactory(factoryName string, f dynamicinformer.DynamicSharedInformerFactory)
+	GetOrCreateDynamicFactory(factoryName string, newFactory func() dynamicinformer.DynamicSharedInformerFactory) dynamicinformer.DynamicSharedInformerFactory
+	GetDynamicFactory(factoryName string) dynamicinformer.DynamicSharedInformerFactory
+	DynamicInformer(gvr schema.GroupVersionResource) (informers.GenericInformer, error)
+	// the factories watching only a namespace or the objects matching selectors
+	ScopedFactory(scope FactoryScope) (informers.SharedInformerFactory, error)
+	ScopedDynamicFactory(scope FactoryScope) (dynamicinformer.DynamicSharedInformerFactory, error)
 	GetConfig() *WebhookServerConfig
+	// the clients shared by the server and the plugins
+	KubeClient() (kubernetes.Interface, error)
+	DynamicClient() (dynamic.Interface, error)
 }
 
 // type WebhookConfigurator interface {
 // 	SetupConfigurator()
 // }
 
-// type WebhookManager interface {
-// 	LoadPlugins()
-// }
+// PluginInfo describes a built-in plugin and where it's enabled
+type PluginInfo struct {
+	Name        string
+	Description string
+	DefaultPath string
+	Enabled     bool
+	Path        string   // path the plugin is enabled at
+	Handlers    []string // names of the handlers the plugin registered
+	ConfigKey   string   // key of the ConfigMap with the plugin config
+	ConfigError error    // why the last config update was rejected
+}
+
+// WebhookManager enables and disables the built-in plugins on a server
+type WebhookManager interface {
+	Plugins() []PluginInfo
+	Plugin(name string) (PluginInfo, error)
+	Enable(name, path string) error
+	Disable(name string) error
+	// Sync makes the given plugins, by name, the enabled ones, at the
+	// given paths (empty for the default one)
+	Sync(plugins map[string]string) map[string]error
+}
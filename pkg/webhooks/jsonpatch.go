@@ -0,0 +1,159 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// MarshalJSON keeps the value of add, replace and test operations even when
+// it's null, while omitting it for remove
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	return json.Marshal(struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}{op.Op, op.Path, op.Value})
+}
+
+// CreatePatch returns the RFC 6902 operations turning the original JSON
+// document into the modified one
+func CreatePatch(original, modified []byte) ([]PatchOperation, error) {
+	return createPatch(original, modified, original)
+}
+
+// createPatch diffs original and modified, both encoded from the same typed
+// object, and writes the operations against raw, the document actually sent
+// by the API server. Fields only appearing because of the round trip
+// (e.g. "creationTimestamp": null) are left out of the patch, and fields
+// unknown to the type are never removed.
+func createPatch(original, modified, raw []byte) ([]PatchOperation, error) {
+	var o, m, r interface{}
+	for _, doc := range []struct {
+		data []byte
+		into *interface{}
+	}{{original, &o}, {modified, &m}, {raw, &r}} {
+		if err := decodeJSON(doc.data, doc.into); err != nil {
+			return nil, err
+		}
+	}
+	return diffValues("", o, m, r, nil), nil
+}
+
+func diffValues(path string, original, modified, raw interface{}, ops []PatchOperation) []PatchOperation {
+	if reflect.DeepEqual(original, modified) {
+		return ops
+	}
+	switch m := modified.(type) {
+	case map[string]interface{}:
+		o, oOk := original.(map[string]interface{})
+		r, rOk := raw.(map[string]interface{})
+		if oOk && rOk {
+			return diffObjects(path, o, m, r, ops)
+		}
+	case []interface{}:
+		o, oOk := original.([]interface{})
+		r, rOk := raw.([]interface{})
+		if oOk && rOk && len(o) == len(r) {
+			return diffArrays(path, o, m, r, ops)
+		}
+	}
+	return append(ops, PatchOperation{Op: "replace", Path: path, Value: modified})
+}
+
+func diffObjects(path string, original, modified, raw map[string]interface{}, ops []PatchOperation) []PatchOperation {
+	for _, k := range sortedKeys(original) {
+		if _, ok := modified[k]; ok {
+			continue
+		}
+		if _, inRaw := raw[k]; inRaw {
+			ops = append(ops, PatchOperation{Op: "remove", Path: path + "/" + pointerEscaper.Replace(k)})
+		}
+	}
+	for _, k := range sortedKeys(modified) {
+		p := path + "/" + pointerEscaper.Replace(k)
+		o, inOriginal := original[k]
+		r, inRaw := raw[k]
+		switch {
+		case !inRaw && inOriginal && reflect.DeepEqual(o, modified[k]):
+			// added by the round trip, not by the mutation
+		case !inRaw:
+			ops = append(ops, PatchOperation{Op: "add", Path: p, Value: modified[k]})
+		case !inOriginal:
+			ops = append(ops, PatchOperation{Op: "replace", Path: p, Value: modified[k]})
+		default:
+			ops = diffValues(p, o, modified[k], r, ops)
+		}
+	}
+	return ops
+}
+
+func diffArrays(path string, original, modified, raw []interface{}, ops []PatchOperation) []PatchOperation {
+	n := len(original)
+	if len(modified) < n {
+		n = len(modified)
+	}
+	for i := 0; i < n; i++ {
+		ops = diffValues(path+"/"+strconv.Itoa(i), original[i], modified[i], raw[i], ops)
+	}
+	for i := n; i < len(modified); i++ {
+		ops = append(ops, PatchOperation{Op: "add", Path: path + "/-", Value: modified[i]})
+	}
+	// backwards, so the indexes stay valid
+	for i := len(original) - 1; i >= n; i-- {
+		ops = append(ops, PatchOperation{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+	}
+	return ops
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PatchResponse admits the request applying the operations, if any
+func PatchResponse(ops []PatchOperation) *admissionV1.AdmissionResponse {
+	if len(ops) == 0 {
+		return &admissionV1.AdmissionResponse{Allowed: true}
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return ErrorResponse(http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+	}
+	pt := admissionV1.PatchTypeJSONPatch
+	return &admissionV1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &pt,
+	}
+}
+
+// ErrorResponse denies the request with the given status
+func ErrorResponse(code int32, reason metav1.StatusReason, message string) *admissionV1.AdmissionResponse {
+	return &admissionV1.AdmissionResponse{
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  reason,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,179 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCreatePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		modified string
+		raw      string
+		want     string
+	}{
+		{
+			name:     "unchanged",
+			original: `{"a":1}`,
+			modified: `{"a":1}`,
+			raw:      `{"a":1}`,
+			want:     `null`,
+		},
+		{
+			name:     "replace a value",
+			original: `{"spec":{"image":"old"}}`,
+			modified: `{"spec":{"image":"new"}}`,
+			raw:      `{"spec":{"image":"old"}}`,
+			want:     `[{"op":"replace","path":"/spec/image","value":"new"}]`,
+		},
+		{
+			name:     "add a field with an escaped key",
+			original: `{"metadata":{}}`,
+			modified: `{"metadata":{"labels":{"a/b~c":"x"}}}`,
+			raw:      `{"metadata":{}}`,
+			want:     `[{"op":"add","path":"/metadata/labels","value":{"a/b~c":"x"}}]`,
+		},
+		{
+			name:     "remove a field",
+			original: `{"metadata":{"annotations":{"a":"b"}}}`,
+			modified: `{"metadata":{}}`,
+			raw:      `{"metadata":{"annotations":{"a":"b"}}}`,
+			want:     `[{"op":"remove","path":"/metadata/annotations"}]`,
+		},
+		{
+			name:     "fields added by the round trip are left out",
+			original: `{"metadata":{"creationTimestamp":null,"name":"x"}}`,
+			modified: `{"metadata":{"creationTimestamp":null,"name":"y"}}`,
+			raw:      `{"metadata":{"name":"x"}}`,
+			want:     `[{"op":"replace","path":"/metadata/name","value":"y"}]`,
+		},
+		{
+			name:     "fields unknown to the type are kept",
+			original: `{"spec":{"a":1}}`,
+			modified: `{"spec":{"a":2}}`,
+			raw:      `{"spec":{"a":1,"unknown":true}}`,
+			want:     `[{"op":"replace","path":"/spec/a","value":2}]`,
+		},
+		{
+			name:     "null value kept in the patch",
+			original: `{"a":1}`,
+			modified: `{"a":null}`,
+			raw:      `{"a":1}`,
+			want:     `[{"op":"replace","path":"/a","value":null}]`,
+		},
+		{
+			name:     "append to an array",
+			original: `{"containers":[{"name":"c"}]}`,
+			modified: `{"containers":[{"name":"c"},{"name":"side"}]}`,
+			raw:      `{"containers":[{"name":"c"}]}`,
+			want:     `[{"op":"add","path":"/containers/-","value":{"name":"side"}}]`,
+		},
+		{
+			name:     "shrink an array from the end",
+			original: `{"a":[1,2,3]}`,
+			modified: `{"a":[1]}`,
+			raw:      `{"a":[1,2,3]}`,
+			want:     `[{"op":"remove","path":"/a/2"},{"op":"remove","path":"/a/1"}]`,
+		},
+		{
+			name:     "diff array items in place",
+			original: `{"a":[{"x":1},{"x":2}]}`,
+			modified: `{"a":[{"x":1},{"x":3}]}`,
+			raw:      `{"a":[{"x":1},{"x":2}]}`,
+			want:     `[{"op":"replace","path":"/a/1/x","value":3}]`,
+		},
+		{
+			name:     "replace an array the raw document doesn't match",
+			original: `{"a":[1,2]}`,
+			modified: `{"a":[1,3]}`,
+			raw:      `{"a":[1]}`,
+			want:     `[{"op":"replace","path":"/a","value":[1,3]}]`,
+		},
+		{
+			name:     "replace a value of another type",
+			original: `{"a":{"b":1}}`,
+			modified: `{"a":"b"}`,
+			raw:      `{"a":{"b":1}}`,
+			want:     `[{"op":"replace","path":"/a","value":"b"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := createPatch([]byte(tt.original), []byte(tt.modified), []byte(tt.raw))
+			if err != nil {
+				t.Fatalf("createPatch() error = %v", err)
+			}
+			got, err := json.Marshal(ops)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("createPatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatePatchInvalidJSON(t *testing.T) {
+	if _, err := CreatePatch([]byte(`{"a":`), []byte(`{}`)); err == nil {
+		t.Error("CreatePatch() of invalid JSON should fail")
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		original interface{}
+		modified interface{}
+		raw      interface{}
+		want     []PatchOperation
+	}{
+		{
+			name:     "equal",
+			path:     "/a",
+			original: "x",
+			modified: "x",
+			raw:      "x",
+		},
+		{
+			name:     "scalar",
+			path:     "/a",
+			original: "x",
+			modified: "y",
+			raw:      "x",
+			want:     []PatchOperation{{Op: "replace", Path: "/a", Value: "y"}},
+		},
+		{
+			name:     "object missing from raw is replaced",
+			path:     "/a",
+			original: map[string]interface{}{"b": "x"},
+			modified: map[string]interface{}{"b": "y"},
+			raw:      nil,
+			want:     []PatchOperation{{Op: "replace", Path: "/a", Value: map[string]interface{}{"b": "y"}}},
+		},
+		{
+			name:     "removed keys first, sorted",
+			path:     "",
+			original: map[string]interface{}{"b": "x", "a": "x", "c": "x"},
+			modified: map[string]interface{}{"c": "y"},
+			raw:      map[string]interface{}{"b": "x", "a": "x", "c": "x"},
+			want: []PatchOperation{
+				{Op: "remove", Path: "/a"},
+				{Op: "remove", Path: "/b"},
+				{Op: "replace", Path: "/c", Value: "y"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffValues(tt.path, tt.original, tt.modified, tt.raw, nil)
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("diffValues() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
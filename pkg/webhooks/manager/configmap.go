@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// PluginsKey is the key of the ConfigMap listing the enabled plugins
+const PluginsKey string = "Plugins"
+
+// ParsePluginsYAML parses the plugins key of the ConfigMap, mapping the names
+// of the enabled plugins to their paths, empty (or null) for the default one:
+//
+//	deployment-affinity:
+//	ingress-rewrite-target: /ingress/rewrite-target
+func ParsePluginsYAML(data string) (map[string]string, error) {
+	var paths map[string]*string
+	if err := yaml.Unmarshal([]byte(data), &paths); err != nil {
+		return nil, err
+	}
+	plugins := make(map[string]string, len(paths))
+	for name, path := range paths {
+		if _, found := lookupPlugin(name); !found {
+			return nil, errors.New(fmt.Sprintf("Unknown plugin %s", name))
+		}
+		plugins[name] = ""
+		if path != nil {
+			plugins[name] = *path
+		}
+	}
+	return plugins, nil
+}
+
+// WatchConfigMap keeps the enabled plugins in sync with the PluginsKey of the
+// ConfigMap of the server (--use-config-map). Once set, the key wins over the
+// plugins enabled at startup; an invalid value leaves the plugins as they are.
+func WatchConfigMap(m WebhookManager, server WebhookServer) error {
+	config := server.GetConfig()
+	f, err := server.ScopedFactory(config.ConfigMapScope())
+	if err != nil {
+		return err
+	}
+	sync := func(obj interface{}) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return
+		}
+		data, found := cm.Data[PluginsKey]
+		if !found {
+			return
+		}
+		plugins, err := ParsePluginsYAML(data)
+		if err != nil {
+			klog.Errorf("Invalid %s in ConfigMap %s/%s, keeping the plugins as they are: %v",
+				PluginsKey, cm.Namespace, cm.Name, err)
+			return
+		}
+		for name, err := range m.Sync(plugins) {
+			klog.Errorf("Can't sync plugin %s: %v", name, err)
+		}
+	}
+	f.Core().V1().ConfigMaps().Informer().AddEventHandler(
+		cache.FilteringResourceEventHandler{
+			FilterFunc: func(obj interface{}) bool {
+				cm, ok := obj.(*corev1.ConfigMap)
+				return ok &&
+					cm.ObjectMeta.Namespace == config.CmNamespace &&
+					cm.ObjectMeta.Name == config.CmName
+			},
+			Handler: cache.ResourceEventHandlerFuncs{
+				AddFunc: sync,
+				UpdateFunc: func(old interface{}, new interface{}) {
+					sync(new)
+				},
+			},
+		})
+	return nil
+}
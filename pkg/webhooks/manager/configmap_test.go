@@ -0,0 +1,35 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePluginsYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", data: "", want: map[string]string{}},
+		{
+			name: "default and custom paths",
+			data: "test-two-handlers:\ntest-counted: /moved\n",
+			want: map[string]string{"test-two-handlers": "", "test-counted": "/moved"},
+		},
+		{name: "unknown plugin", data: "test-unknown:\n", wantErr: true},
+		{name: "not a map", data: "- test-counted\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePluginsYAML(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePluginsYAML() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePluginsYAML() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
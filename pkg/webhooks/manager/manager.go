@@ -2,12 +2,341 @@ package manager
 
 import (
 	"errors"
+	"fmt"
 	"sort"
 	"strings"
+	"sync"
 
-	adminssionV1beta1 "k8s.io/api/admission/v1beta1"
-	"k8s.io/client-go/informers"
+	"k8s.io/klog"
 
 	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
-	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks/server"
 )
+
+// Plugin is a built-in plugin, registered by the init function of its package
+type Plugin struct {
+	Name        string
+	Description string
+	DefaultPath string
+	New         func() WebhookHandler
+	// Config is the section of the ConfigMap the plugin reads its
+	// configuration from, if any
+	Config *ConfigSection
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Plugin)
+)
+
+// Register makes the plugin available to the managers, it panics when the
+// name is already taken
+func Register(p Plugin) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if p.Name == "" || p.New == nil {
+		panic("manager: Register of a plugin without name or constructor")
+	}
+	if _, dup := registry[p.Name]; dup {
+		panic("manager: Register called twice for plugin " + p.Name)
+	}
+	registry[p.Name] = p
+}
+
+func lookupPlugin(name string) (Plugin, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	p, found := registry[name]
+	return p, found
+}
+
+type registration struct {
+	path    string
+	name    string
+	handler AdmissionHandler
+	opts    []HandlerOption
+}
+
+// pluginState is kept once a plugin is set up, so it can be disabled and
+// enabled again, or moved, without running its Setup twice
+type pluginState struct {
+	enabled  bool
+	path     string
+	handlers []registration
+}
+
+type webhookManager struct {
+	server  WebhookServer
+	lock    sync.Mutex
+	plugins map[string]*pluginState
+}
+
+var _ WebhookManager = &webhookManager{}
+
+func NewWebhookManager(server WebhookServer) WebhookManager {
+	return &webhookManager{
+		server:  server,
+		plugins: make(map[string]*pluginState),
+	}
+}
+
+// Plugins returns the registered plugins, sorted by name
+func (m *webhookManager) Plugins() []PluginInfo {
+	registryLock.RLock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryLock.RUnlock()
+	sort.Strings(names)
+
+	infos := make([]PluginInfo, 0, len(names))
+	for _, name := range names {
+		info, _ := m.Plugin(name)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (m *webhookManager) Plugin(name string) (PluginInfo, error) {
+	p, found := lookupPlugin(name)
+	if !found {
+		return PluginInfo{}, errors.New(fmt.Sprintf("Unknown plugin %s", name))
+	}
+	info := PluginInfo{
+		Name:        p.Name,
+		Description: p.Description,
+		DefaultPath: p.DefaultPath,
+	}
+	if p.Config != nil {
+		info.ConfigKey = p.Config.Key
+		info.ConfigError = p.Config.Err()
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if ps, found := m.plugins[name]; found && ps.enabled {
+		info.Enabled = true
+		info.Path = ps.path
+		for _, r := range ps.handlers {
+			info.Handlers = append(info.Handlers, r.name)
+		}
+	}
+	return info, nil
+}
+
+// Enable sets the plugin up at the path, its default path when empty
+func (m *webhookManager) Enable(name, path string) error {
+	p, path, err := pluginAndPath(name, path)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if ps, found := m.plugins[name]; found && ps.enabled {
+		return errors.New(fmt.Sprintf("Plugin %s is already enabled at %s", name, ps.path))
+	}
+	return m.enable(p, path)
+}
+
+// Disable unregisters the handlers of the plugin. What the plugin set up
+// besides its handlers (e.g. informers) stays in place.
+func (m *webhookManager) Disable(name string) error {
+	if _, found := lookupPlugin(name); !found {
+		return errors.New(fmt.Sprintf("Unknown plugin %s", name))
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.disable(name)
+}
+
+// Sync enables the plugins given as name to path (empty for the default
+// path), moving the ones enabled at another path, and disables the others
+func (m *webhookManager) Sync(plugins map[string]string) map[string]error {
+	errs := make(map[string]error)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for name, ps := range m.plugins {
+		if _, wanted := plugins[name]; !wanted && ps.enabled {
+			if err := m.disable(name); err != nil {
+				errs[name] = err
+			}
+		}
+	}
+	for name, path := range plugins {
+		p, path, err := pluginAndPath(name, path)
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		ps, found := m.plugins[name]
+		switch {
+		case found && ps.enabled && ps.path == path:
+			continue
+		case found && ps.enabled:
+			err = m.move(name, path)
+		default:
+			err = m.enable(p, path)
+		}
+		if err != nil {
+			errs[name] = err
+		}
+	}
+	return errs
+}
+
+func pluginAndPath(name, path string) (Plugin, string, error) {
+	p, found := lookupPlugin(name)
+	if !found {
+		return p, path, errors.New(fmt.Sprintf("Unknown plugin %s", name))
+	}
+	if path == "" {
+		path = p.DefaultPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		return p, path, errors.New(fmt.Sprintf("Path %s of plugin %s doesn't start with /", path, name))
+	}
+	return p, path, nil
+}
+
+// enable runs the Setup of the plugin the first time, then registers again
+// the handlers it registered
+func (m *webhookManager) enable(p Plugin, path string) (err error) {
+	if ps, found := m.plugins[p.Name]; found {
+		handlers := moveRegistrations(ps.handlers, ps.path, path)
+		if err := register(m.server, handlers); err != nil {
+			return err
+		}
+		ps.enabled, ps.path, ps.handlers = true, path, handlers
+		klog.Infof("Enabled plugin %s at path %s", p.Name, path)
+		return nil
+	}
+
+	rec := &recorder{WebhookServer: m.server}
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("Plugin %s panicked: %v", p.Name, r))
+		}
+		if err != nil {
+			// don't leave half of the plugin registered
+			unregister(m.server, rec.handlers)
+		}
+	}()
+	p.New().Setup(rec, path)
+	if len(rec.handlers) == 0 {
+		return errors.New(fmt.Sprintf("Plugin %s registered no handler at %s", p.Name, path))
+	}
+	// the informers the plugin added, when the server is already running
+	m.server.StartInformers()
+	m.plugins[p.Name] = &pluginState{enabled: true, path: path, handlers: rec.handlers}
+	klog.Infof("Enabled plugin %s at path %s", p.Name, path)
+	return nil
+}
+
+func (m *webhookManager) disable(name string) error {
+	ps, found := m.plugins[name]
+	if !found || !ps.enabled {
+		return errors.New(fmt.Sprintf("Plugin %s is not enabled", name))
+	}
+	if err := unregister(m.server, ps.handlers); err != nil {
+		return err
+	}
+	ps.enabled = false
+	klog.Infof("Disabled plugin %s at path %s", name, ps.path)
+	return nil
+}
+
+// move registers the handlers at the new path before unregistering them from
+// the old one, so the plugin doesn't miss any request
+func (m *webhookManager) move(name, path string) error {
+	ps := m.plugins[name]
+	handlers := moveRegistrations(ps.handlers, ps.path, path)
+	if err := register(m.server, handlers); err != nil {
+		return err
+	}
+	if err := unregister(m.server, ps.handlers); err != nil {
+		// stay at the old path
+		unregister(m.server, handlers)
+		return errors.New(fmt.Sprintf("Can't unregister plugin %s from %s: %v", name, ps.path, err))
+	}
+	klog.Infof("Moved plugin %s from path %s to %s", name, ps.path, path)
+	ps.path, ps.handlers = path, handlers
+	return nil
+}
+
+// moveRegistrations returns the registrations with the from path (or prefix)
+// replaced by to
+func moveRegistrations(handlers []registration, from, to string) []registration {
+	moved := make([]registration, len(handlers))
+	for i, r := range handlers {
+		moved[i] = r
+		if strings.HasPrefix(r.path, from) {
+			moved[i].path = to + strings.TrimPrefix(r.path, from)
+		}
+	}
+	return moved
+}
+
+// EnablePlugins enables the plugins given as name or name=path, returning
+// the errors by plugin
+func EnablePlugins(m WebhookManager, specs []string) map[string]error {
+	errs := make(map[string]error)
+	for _, spec := range specs {
+		name, path := ParsePluginSpec(spec)
+		if err := m.Enable(name, path); err != nil {
+			errs[name] = err
+		}
+	}
+	return errs
+}
+
+// ParsePluginSpec splits name=path, the path is empty for the default one
+func ParsePluginSpec(spec string) (string, string) {
+	name := strings.TrimSpace(spec)
+	path := ""
+	if i := strings.Index(name, "="); i >= 0 {
+		name, path = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+	}
+	return name, path
+}
+
+// register registers all the handlers, or none of them
+func register(server WebhookServer, handlers []registration) error {
+	for i, r := range handlers {
+		if err := server.RegisterHandler(r.path, r.handler, r.opts...); err != nil {
+			unregister(server, handlers[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// unregister unregisters all the handlers, or none of them
+func unregister(server WebhookServer, handlers []registration) error {
+	for i, r := range handlers {
+		if err := server.UnregisterHandler(r.path, r.name); err != nil {
+			register(server, handlers[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// recorder passes the calls of a plugin to the server, keeping track of the
+// handlers it registers so they can be unregistered
+type recorder struct {
+	WebhookServer
+	handlers []registration
+}
+
+func (r *recorder) RegisterHandler(path string, h AdmissionHandler, opts ...HandlerOption) error {
+	if err := r.WebhookServer.RegisterHandler(path, h, opts...); err != nil {
+		return err
+	}
+	r.handlers = append(r.handlers, registration{
+		path:    path,
+		name:    NewHandlerConfig(h, opts...).Name,
+		handler: h,
+		opts:    opts,
+	})
+	return nil
+}
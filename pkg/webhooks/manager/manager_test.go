@@ -0,0 +1,317 @@
+package manager
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// fakeServer keeps the names of the handlers registered by path, the calls
+// for the paths in failing fail
+type fakeServer struct {
+	WebhookServer
+	handlers        map[string][]string
+	failing         map[string]bool
+	informersStarts int
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{handlers: make(map[string][]string), failing: make(map[string]bool)}
+}
+
+func (s *fakeServer) RegisterHandler(path string, h AdmissionHandler, opts ...HandlerOption) error {
+	name := NewHandlerConfig(h, opts...).Name
+	if s.failing[path] {
+		return fmt.Errorf("can't register %s for path %s", name, path)
+	}
+	for _, n := range s.handlers[path] {
+		if n == name {
+			return fmt.Errorf("handler %s for path %s already exists", name, path)
+		}
+	}
+	s.handlers[path] = append(s.handlers[path], name)
+	return nil
+}
+
+func (s *fakeServer) UnregisterHandler(path, name string) error {
+	if s.failing[path] {
+		return fmt.Errorf("can't unregister %s for path %s", name, path)
+	}
+	for i, n := range s.handlers[path] {
+		if n == name {
+			s.handlers[path] = append(s.handlers[path][:i], s.handlers[path][i+1:]...)
+			if len(s.handlers[path]) == 0 {
+				delete(s.handlers, path)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no handler %s for path %s", name, path)
+}
+
+// StartInformers counts its calls
+func (s *fakeServer) StartInformers() {
+	s.informersStarts++
+}
+
+// setupFunc adapts a func to WebhookHandler
+type setupFunc func(WebhookServer, string)
+
+func (f setupFunc) Setup(ws WebhookServer, path string) {
+	f(ws, path)
+}
+
+func registering(names ...string) func() WebhookHandler {
+	return func() WebhookHandler {
+		return setupFunc(func(ws WebhookServer, path string) {
+			for _, name := range names {
+				ws.RegisterHandler(path, AdmitAlways, WithHandlerName(name))
+			}
+		})
+	}
+}
+
+// setups counts the calls of the Setup of test-counted
+var setups int
+
+func init() {
+	Register(Plugin{Name: "test-two-handlers", DefaultPath: "/two", New: registering("first", "second")})
+	Register(Plugin{Name: "test-counted", DefaultPath: "/counted", New: func() WebhookHandler {
+		return setupFunc(func(ws WebhookServer, path string) {
+			setups++
+			ws.RegisterHandler(path, AdmitAlways, WithHandlerName("counted"))
+			ws.RegisterHandler(path+"/sub", AdmitAlways, WithHandlerName("counted-sub"))
+		})
+	}})
+	Register(Plugin{Name: "test-no-handler", DefaultPath: "/none", New: registering()})
+	Register(Plugin{Name: "test-panic", DefaultPath: "/panic", New: func() WebhookHandler {
+		return setupFunc(func(ws WebhookServer, path string) {
+			ws.RegisterHandler(path, AdmitAlways, WithHandlerName("before-panic"))
+			panic("boom")
+		})
+	}})
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin Plugin
+	}{
+		{name: "duplicate", plugin: Plugin{Name: "test-two-handlers", New: registering()}},
+		{name: "without name", plugin: Plugin{New: registering()}},
+		{name: "without constructor", plugin: Plugin{Name: "test-nil"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Register() should panic")
+				}
+			}()
+			Register(tt.plugin)
+		})
+	}
+}
+
+func TestEnableDisable(t *testing.T) {
+	server := newFakeServer()
+	m := NewWebhookManager(server)
+
+	if err := m.Enable("test-two-handlers", ""); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if err := m.Enable("test-two-handlers", "/other"); err == nil {
+		t.Error("Enable() of an enabled plugin should fail")
+	}
+	if server.informersStarts != 1 {
+		t.Errorf("StartInformers() called %d times by Enable(), want once", server.informersStarts)
+	}
+	if !reflect.DeepEqual(server.handlers, map[string][]string{"/two": {"first", "second"}}) {
+		t.Errorf("handlers = %v, want first and second at the default path", server.handlers)
+	}
+	info, err := m.Plugin("test-two-handlers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PluginInfo{Name: "test-two-handlers", DefaultPath: "/two", Enabled: true, Path: "/two",
+		Handlers: []string{"first", "second"}}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("Plugin() = %+v, want %+v", info, want)
+	}
+
+	if err := m.Disable("test-two-handlers"); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if len(server.handlers) != 0 {
+		t.Errorf("handlers = %v after Disable(), want none", server.handlers)
+	}
+	if err := m.Disable("test-two-handlers"); err == nil {
+		t.Error("Disable() of a disabled plugin should fail")
+	}
+	if err := m.Enable("test-two-handlers", "/custom"); err != nil {
+		t.Fatalf("Enable() at another path error = %v", err)
+	}
+	if _, found := server.handlers["/custom"]; !found {
+		t.Errorf("handlers = %v, want them at /custom", server.handlers)
+	}
+
+	var names []string
+	for _, info := range m.Plugins() {
+		names = append(names, info.Name)
+	}
+	if !sort.StringsAreSorted(names) || len(names) != 4 {
+		t.Errorf("Plugins() = %v, want the 4 test plugins sorted", names)
+	}
+}
+
+func TestEnableErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		plugin string
+		path   string
+	}{
+		{name: "unknown plugin", plugin: "test-unknown"},
+		{name: "relative path", plugin: "test-two-handlers", path: "two"},
+		{name: "no handler", plugin: "test-no-handler"},
+		{name: "panic", plugin: "test-panic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer()
+			m := NewWebhookManager(server)
+			if err := m.Enable(tt.plugin, tt.path); err == nil {
+				t.Fatal("Enable() should fail")
+			}
+			// nothing is left registered
+			if len(server.handlers) != 0 {
+				t.Errorf("handlers = %v, want none", server.handlers)
+			}
+			if info, _ := m.Plugin(tt.plugin); info.Enabled {
+				t.Errorf("Plugin() = %+v, want it disabled", info)
+			}
+		})
+	}
+}
+
+func TestEnablePlugins(t *testing.T) {
+	server := newFakeServer()
+	m := NewWebhookManager(server)
+	errs := EnablePlugins(m, []string{"test-two-handlers = /custom", "test-unknown", " test-no-handler "})
+	if len(errs) != 2 || errs["test-unknown"] == nil || errs["test-no-handler"] == nil {
+		t.Errorf("EnablePlugins() = %v, want the errors of test-unknown and test-no-handler", errs)
+	}
+	if info, _ := m.Plugin("test-two-handlers"); !info.Enabled || info.Path != "/custom" {
+		t.Errorf("Plugin() = %+v, want it enabled at /custom", info)
+	}
+}
+
+func TestParsePluginSpec(t *testing.T) {
+	tests := []struct {
+		spec, name, path string
+	}{
+		{spec: "deployment-affinity", name: "deployment-affinity"},
+		{spec: "ingress-rewrite-target=/ingress/rewrite-target", name: "ingress-rewrite-target", path: "/ingress/rewrite-target"},
+		{spec: " jive-webapps-affinity = /jive ", name: "jive-webapps-affinity", path: "/jive"},
+		{spec: "name=", name: "name"},
+	}
+	for _, tt := range tests {
+		if name, path := ParsePluginSpec(tt.spec); name != tt.name || path != tt.path {
+			t.Errorf("ParsePluginSpec(%q) = %q, %q, want %q, %q", tt.spec, name, path, tt.name, tt.path)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	server := newFakeServer()
+	m := NewWebhookManager(server)
+	setups = 0
+
+	steps := []struct {
+		name     string
+		plugins  map[string]string
+		want     map[string][]string
+		wantErrs []string
+	}{
+		{
+			name:    "enable",
+			plugins: map[string]string{"test-two-handlers": "", "test-counted": ""},
+			want: map[string][]string{"/two": {"first", "second"},
+				"/counted": {"counted"}, "/counted/sub": {"counted-sub"}},
+		},
+		{
+			name:    "move",
+			plugins: map[string]string{"test-two-handlers": "", "test-counted": "/moved"},
+			want: map[string][]string{"/two": {"first", "second"},
+				"/moved": {"counted"}, "/moved/sub": {"counted-sub"}},
+		},
+		{
+			name:    "disable",
+			plugins: map[string]string{"test-counted": "/moved"},
+			want:    map[string][]string{"/moved": {"counted"}, "/moved/sub": {"counted-sub"}},
+		},
+		{
+			name:    "enable again",
+			plugins: map[string]string{"test-counted": ""},
+			want:    map[string][]string{"/counted": {"counted"}, "/counted/sub": {"counted-sub"}},
+		},
+		{
+			name:     "unknown and invalid",
+			plugins:  map[string]string{"test-counted": "", "test-unknown": "", "test-two-handlers": "two"},
+			want:     map[string][]string{"/counted": {"counted"}, "/counted/sub": {"counted-sub"}},
+			wantErrs: []string{"test-two-handlers", "test-unknown"},
+		},
+	}
+	for _, step := range steps {
+		errs := m.Sync(step.plugins)
+		var names []string
+		for name := range errs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, step.wantErrs) {
+			t.Errorf("%s: Sync() errors = %v, want errors for %v", step.name, errs, step.wantErrs)
+		}
+		if !reflect.DeepEqual(server.handlers, step.want) {
+			t.Errorf("%s: handlers = %v, want %v", step.name, server.handlers, step.want)
+		}
+	}
+	if setups != 1 {
+		t.Errorf("Setup called %d times, want once", setups)
+	}
+}
+
+func TestSyncRollback(t *testing.T) {
+	server := newFakeServer()
+	m := NewWebhookManager(server)
+	if errs := m.Sync(map[string]string{"test-counted": ""}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	enabled := map[string][]string{"/counted": {"counted"}, "/counted/sub": {"counted-sub"}}
+
+	// the second handler can't be registered at the new path
+	server.failing["/moved/sub"] = true
+	if errs := m.Sync(map[string]string{"test-counted": "/moved"}); errs["test-counted"] == nil {
+		t.Error("Sync() moving to a failing path should fail")
+	}
+	if !reflect.DeepEqual(server.handlers, enabled) {
+		t.Errorf("handlers = %v after a failed move, want %v", server.handlers, enabled)
+	}
+	if info, _ := m.Plugin("test-counted"); info.Path != "/counted" {
+		t.Errorf("Plugin() = %+v, want it still at /counted", info)
+	}
+
+	// the second handler can't be unregistered
+	server.failing["/counted/sub"] = true
+	if err := m.Disable("test-counted"); err == nil {
+		t.Error("Disable() should fail")
+	}
+	if !reflect.DeepEqual(server.handlers, enabled) {
+		t.Errorf("handlers = %v after a failed disable, want %v", server.handlers, enabled)
+	}
+	if info, _ := m.Plugin("test-counted"); !info.Enabled {
+		t.Errorf("Plugin() = %+v, want it still enabled", info)
+	}
+}
@@ -0,0 +1,43 @@
+package manager
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+var pluginConfigValidDesc = prometheus.NewDesc(
+	prometheus.BuildFQName("webhooks", "plugin", "config_valid"),
+	"Whether the last update of the ConfigMap key of the plugin was applied (1) or rejected (0).",
+	[]string{"plugin", "key"}, nil,
+)
+
+// pluginsCollector reports the configuration status of the plugins having a
+// ConfigMap section
+type pluginsCollector struct {
+	m WebhookManager
+}
+
+// RegisterMetrics exposes the configuration status of the plugins of the
+// manager with the metrics of the server
+func RegisterMetrics(m WebhookManager) error {
+	return prometheus.Register(&pluginsCollector{m: m})
+}
+
+func (pc *pluginsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pluginConfigValidDesc
+}
+
+func (pc *pluginsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, info := range pc.m.Plugins() {
+		if info.ConfigKey == "" {
+			continue
+		}
+		valid := 1.0
+		if info.ConfigError != nil {
+			valid = 0
+		}
+		ch <- prometheus.MustNewConstMetric(pluginConfigValidDesc, prometheus.GaugeValue, valid,
+			info.Name, info.ConfigKey)
+	}
+}
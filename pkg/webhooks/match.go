@@ -0,0 +1,154 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// MatchAll matches any group, version, kind or subresource
+const MatchAll string = "*"
+
+// NamespaceLabelsFunc returns the labels of the namespace with the given name
+type NamespaceLabelsFunc func(name string) (map[string]string, error)
+
+// Match restricts the requests a handler is called for, empty fields match
+// everything, except SubResources where only the main resource is matched.
+// Requests not matched by any handler of the path get the DefaultAdmitPolicy.
+type Match struct {
+	// Kinds of the object, MatchAll can be used for group, version or kind
+	Kinds []metav1.GroupVersionKind
+	// Operations matched (CREATE, UPDATE, DELETE, CONNECT)
+	Operations []admissionV1.Operation
+	// SubResources matched (e.g. "status", "scale"), MatchAll for any
+	SubResources []string
+	// Namespaces names matched, cluster-scoped objects always match
+	Namespaces []string
+	// NamespaceSelector applied to the labels of the namespace of the object,
+	// cluster-scoped objects always match
+	NamespaceSelector labels.Selector
+	// ObjectSelector applied to the labels of the object (the old one on DELETE)
+	ObjectSelector labels.Selector
+}
+
+func WithMatch(m Match) HandlerOption {
+	return func(hc *HandlerConfig) {
+		hc.Match = &m
+	}
+}
+
+// Matches tells if the request is selected by the match, namespaceLabels is
+// only called when a NamespaceSelector is set
+func (m *Match) Matches(req *admissionV1.AdmissionRequest, namespaceLabels NamespaceLabelsFunc) (bool, error) {
+	if !m.matchKind(req.Kind) || !m.matchOperation(req.Operation) || !m.matchSubResource(req.SubResource) {
+		return false, nil
+	}
+	if len(m.Namespaces) > 0 && req.Namespace != "" && !containsString(m.Namespaces, req.Namespace) {
+		return false, nil
+	}
+
+	if m.ObjectSelector != nil && !m.ObjectSelector.Empty() {
+		objLabels, err := objectLabels(req)
+		if err != nil {
+			return false, err
+		}
+		if !m.ObjectSelector.Matches(labels.Set(objLabels)) {
+			return false, nil
+		}
+	}
+
+	if m.NamespaceSelector != nil && !m.NamespaceSelector.Empty() {
+		var nsLabels map[string]string
+		switch {
+		case req.Kind.Group == "" && req.Kind.Kind == "Namespace":
+			// the namespace itself is matched by its own labels
+			objLabels, err := objectLabels(req)
+			if err != nil {
+				return false, err
+			}
+			nsLabels = objLabels
+		case req.Namespace == "":
+			return true, nil
+		case namespaceLabels == nil:
+			return false, fmt.Errorf("can't get the labels of namespace %s", req.Namespace)
+		default:
+			var err error
+			if nsLabels, err = namespaceLabels(req.Namespace); err != nil {
+				return false, err
+			}
+		}
+		if !m.NamespaceSelector.Matches(labels.Set(nsLabels)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *Match) matchKind(gvk metav1.GroupVersionKind) bool {
+	if len(m.Kinds) == 0 {
+		return true
+	}
+	for _, k := range m.Kinds {
+		if matchString(k.Group, gvk.Group) && matchString(k.Version, gvk.Version) && matchString(k.Kind, gvk.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Match) matchOperation(op admissionV1.Operation) bool {
+	if len(m.Operations) == 0 {
+		return true
+	}
+	for _, o := range m.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Match) matchSubResource(subResource string) bool {
+	if len(m.SubResources) == 0 {
+		return subResource == ""
+	}
+	for _, s := range m.SubResources {
+		if matchString(s, subResource) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchString(pattern, value string) bool {
+	return pattern == MatchAll || pattern == value
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// objectLabels returns the labels of the object of the request, or of the
+// old object when there's no new one (DELETE)
+func objectLabels(req *admissionV1.AdmissionRequest) (map[string]string, error) {
+	raw := req.Object.Raw
+	if len(raw) == 0 {
+		raw = req.OldObject.Raw
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var obj metav1.PartialObjectMetadata
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("can't read the labels of the object: %v", err)
+	}
+	return obj.Labels, nil
+}
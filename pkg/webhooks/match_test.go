@@ -0,0 +1,155 @@
+package webhooks
+
+import (
+	"errors"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	deploymentKind = metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	podKind        = metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"}
+	namespaceKind  = metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+)
+
+func TestMatchMatches(t *testing.T) {
+	namespaceLabels := func(name string) (map[string]string, error) {
+		switch name {
+		case "prod":
+			return map[string]string{"env": "prod"}, nil
+		case "dev":
+			return map[string]string{"env": "dev"}, nil
+		}
+		return nil, errors.New("not found")
+	}
+	prod := labels.SelectorFromSet(labels.Set{"env": "prod"})
+	tests := []struct {
+		name    string
+		match   Match
+		req     admissionV1.AdmissionRequest
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "empty match",
+			req:  admissionV1.AdmissionRequest{Kind: podKind, Operation: admissionV1.Create},
+			want: true,
+		},
+		{
+			name: "empty match skips subresources",
+			req:  admissionV1.AdmissionRequest{Kind: podKind, SubResource: "status"},
+			want: false,
+		},
+		{
+			name:  "kind with wildcard version",
+			match: Match{Kinds: []metav1.GroupVersionKind{{Group: "apps", Version: MatchAll, Kind: "Deployment"}}},
+			req:   admissionV1.AdmissionRequest{Kind: deploymentKind},
+			want:  true,
+		},
+		{
+			name:  "other kind",
+			match: Match{Kinds: []metav1.GroupVersionKind{{Group: "apps", Version: MatchAll, Kind: "Deployment"}}},
+			req:   admissionV1.AdmissionRequest{Kind: podKind},
+			want:  false,
+		},
+		{
+			name:  "other operation",
+			match: Match{Operations: []admissionV1.Operation{admissionV1.Create}},
+			req:   admissionV1.AdmissionRequest{Kind: podKind, Operation: admissionV1.Delete},
+			want:  false,
+		},
+		{
+			name:  "subresource",
+			match: Match{SubResources: []string{"scale"}},
+			req:   admissionV1.AdmissionRequest{Kind: deploymentKind, SubResource: "scale"},
+			want:  true,
+		},
+		{
+			name:  "any subresource",
+			match: Match{SubResources: []string{MatchAll}},
+			req:   admissionV1.AdmissionRequest{Kind: podKind, SubResource: "status"},
+			want:  true,
+		},
+		{
+			name:  "other namespace",
+			match: Match{Namespaces: []string{"prod"}},
+			req:   admissionV1.AdmissionRequest{Kind: podKind, Namespace: "dev"},
+			want:  false,
+		},
+		{
+			name:  "namespaces match cluster-scoped objects",
+			match: Match{Namespaces: []string{"prod"}},
+			req:   admissionV1.AdmissionRequest{Kind: namespaceKind},
+			want:  true,
+		},
+		{
+			name:  "object selector",
+			match: Match{ObjectSelector: prod},
+			req:   admissionV1.AdmissionRequest{Kind: podKind, Object: raw(`{"metadata":{"labels":{"env":"prod"}}}`)},
+			want:  true,
+		},
+		{
+			name:  "object selector on the old object",
+			match: Match{ObjectSelector: prod},
+			req: admissionV1.AdmissionRequest{Kind: podKind, Operation: admissionV1.Delete,
+				OldObject: raw(`{"metadata":{"labels":{"env":"dev"}}}`)},
+			want: false,
+		},
+		{
+			name:    "object selector on an invalid object",
+			match:   Match{ObjectSelector: prod},
+			req:     admissionV1.AdmissionRequest{Kind: podKind, Object: raw(`[]`)},
+			wantErr: true,
+		},
+		{
+			name:  "namespace selector",
+			match: Match{NamespaceSelector: prod},
+			req:   admissionV1.AdmissionRequest{Kind: podKind, Namespace: "prod"},
+			want:  true,
+		},
+		{
+			name:  "namespace selector not matching",
+			match: Match{NamespaceSelector: prod},
+			req:   admissionV1.AdmissionRequest{Kind: podKind, Namespace: "dev"},
+			want:  false,
+		},
+		{
+			name:  "namespace selector on the namespace itself",
+			match: Match{NamespaceSelector: prod},
+			req:   admissionV1.AdmissionRequest{Kind: namespaceKind, Name: "new", Object: raw(`{"metadata":{"labels":{"env":"prod"}}}`)},
+			want:  true,
+		},
+		{
+			name:    "namespace selector on an unknown namespace",
+			match:   Match{NamespaceSelector: prod},
+			req:     admissionV1.AdmissionRequest{Kind: podKind, Namespace: "unknown"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.match.Matches(&tt.req, namespaceLabels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Matches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchWithoutNamespaceLabels(t *testing.T) {
+	m := Match{NamespaceSelector: labels.SelectorFromSet(labels.Set{"env": "prod"})}
+	if _, err := m.Matches(&admissionV1.AdmissionRequest{Kind: podKind, Namespace: "prod"}, nil); err == nil {
+		t.Error("Matches() with a NamespaceSelector and no namespace labels should fail")
+	}
+}
+
+func raw(object string) runtime.RawExtension {
+	return runtime.RawExtension{Raw: []byte(object)}
+}
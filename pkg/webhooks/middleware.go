@@ -0,0 +1,99 @@
+package webhooks
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// Middleware wraps an AdmissionHandler adding some behaviour around it
+type Middleware func(AdmissionHandler) AdmissionHandler
+
+// Chain wraps the handler with the middlewares, the first one is the
+// outermost, so it's the first to see the review and the last to see the
+// response
+func Chain(h AdmissionHandler, mws ...Middleware) AdmissionHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Logging logs every review with the outcome of the wrapped handler
+func Logging() Middleware {
+	return func(next AdmissionHandler) AdmissionHandler {
+		return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+			req := ar.Request
+			klog.Infof("[%s] %s %s %s/%s (uid: %s) on %s",
+				ar.Handler, req.Operation, req.Kind.Kind, req.Namespace, req.Name, req.UID, ar.Path)
+			resp := next(ar)
+			switch {
+			case resp == nil:
+				klog.Errorf("[%s] no response for uid: %s", ar.Handler, req.UID)
+			case !resp.Allowed:
+				msg := ""
+				if resp.Result != nil {
+					msg = resp.Result.Message
+				}
+				klog.Infof("[%s] denied uid: %s: %s", ar.Handler, req.UID, msg)
+			case len(resp.Patch) > 0:
+				klog.Infof("[%s] patched uid: %s: %s", ar.Handler, req.UID, string(resp.Patch))
+			default:
+				klog.V(2).Infof("[%s] allowed uid: %s", ar.Handler, req.UID)
+			}
+			return resp
+		}
+	}
+}
+
+// Timing measures the time spent by the wrapped handler, passing it to
+// observe, or logging it when observe is nil
+func Timing(observe func(*AdmissionReview, time.Duration)) Middleware {
+	if observe == nil {
+		observe = func(ar *AdmissionReview, elapsed time.Duration) {
+			klog.V(2).Infof("[%s] uid: %s served in %v", ar.Handler, ar.Request.UID, elapsed)
+		}
+	}
+	return func(next AdmissionHandler) AdmissionHandler {
+		return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+			start := time.Now()
+			defer func() { observe(ar, time.Since(start)) }()
+			return next(ar)
+		}
+	}
+}
+
+// Recovery recovers from panics in the wrapped handler, answering with the
+// response of onPanic, or denying the request when onPanic is nil
+func Recovery(onPanic func(ar *AdmissionReview, recovered interface{}) *admissionV1.AdmissionResponse) Middleware {
+	if onPanic == nil {
+		onPanic = func(ar *AdmissionReview, recovered interface{}) *admissionV1.AdmissionResponse {
+			return &admissionV1.AdmissionResponse{
+				Allowed: false,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    http.StatusInternalServerError,
+					Reason:  metav1.StatusReasonInternalError,
+					Message: fmt.Sprintf("webhook handler %s failed: %v", ar.Handler, recovered),
+				},
+			}
+		}
+	}
+	return func(next AdmissionHandler) AdmissionHandler {
+		return func(ar *AdmissionReview) (resp *admissionV1.AdmissionResponse) {
+			defer func() {
+				if r := recover(); r != nil {
+					klog.Errorf("Handler %s panicked serving %s (uid: %s): %v\n%s",
+						ar.Handler, ar.Path, ar.Request.UID, r, debug.Stack())
+					resp = onPanic(ar, r)
+				}
+			}()
+			return next(ar)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package webhooks
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+func tracing(name string, trace *[]string) Middleware {
+	return func(next AdmissionHandler) AdmissionHandler {
+		return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+			*trace = append(*trace, name+" in")
+			resp := next(ar)
+			*trace = append(*trace, name+" out")
+			return resp
+		}
+	}
+}
+
+func newTestReview() *AdmissionReview {
+	return &AdmissionReview{Request: &admissionV1.AdmissionRequest{UID: "uid"}, Handler: "test", Path: "/test"}
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	h := Chain(func(*AdmissionReview) *admissionV1.AdmissionResponse {
+		trace = append(trace, "handler")
+		return &admissionV1.AdmissionResponse{Allowed: true}
+	}, tracing("a", &trace), tracing("b", &trace))
+
+	if resp := h(newTestReview()); !resp.Allowed {
+		t.Errorf("response = %+v, want the one of the handler", resp)
+	}
+	want := []string{"a in", "b in", "handler", "b out", "a out"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("calls = %v, want %v", trace, want)
+	}
+
+	if h := Chain(AdmitAlways); !h(newTestReview()).Allowed {
+		t.Error("Chain() without middlewares should call the handler")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	panicking := func(*AdmissionReview) *admissionV1.AdmissionResponse { panic("boom") }
+
+	resp := Recovery(nil)(panicking)(newTestReview())
+	if resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusInternalServerError {
+		t.Errorf("response = %+v, want denied with an internal error", resp)
+	}
+
+	var recovered interface{}
+	resp = Recovery(func(ar *AdmissionReview, r interface{}) *admissionV1.AdmissionResponse {
+		recovered = r
+		return &admissionV1.AdmissionResponse{Allowed: true}
+	})(panicking)(newTestReview())
+	if !resp.Allowed || recovered != "boom" {
+		t.Errorf("response = %+v recovered %v, want the onPanic answer", resp, recovered)
+	}
+
+	if resp := Recovery(nil)(AdmitAlways)(newTestReview()); !resp.Allowed {
+		t.Error("Recovery() changed the answer of a handler not panicking")
+	}
+}
+
+func TestTiming(t *testing.T) {
+	var observed time.Duration
+	h := Timing(func(ar *AdmissionReview, elapsed time.Duration) {
+		observed = elapsed
+	})(func(*AdmissionReview) *admissionV1.AdmissionResponse {
+		time.Sleep(10 * time.Millisecond)
+		return &admissionV1.AdmissionResponse{Allowed: true}
+	})
+	if resp := h(newTestReview()); !resp.Allowed {
+		t.Errorf("response = %+v, want the one of the handler", resp)
+	}
+	if observed < 10*time.Millisecond {
+		t.Errorf("observed %v, want at least 10ms", observed)
+	}
+
+	// the default observer only logs
+	Timing(nil)(AdmitAlways)(newTestReview())
+}
@@ -0,0 +1,89 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog"
+)
+
+// ObjectMutator changes in place the object of the request, returning an
+// error denies the request. Leaving the object unchanged admits it as is.
+type ObjectMutator func(ar *AdmissionReview, obj runtime.Object) error
+
+// MutateObject decodes the object of the request in the type registered for
+// its kind (unstructured.Unstructured for unknown kinds), and answers with the
+// JSON Patch of the changes made by the mutator
+func MutateObject(mutate ObjectMutator) AdmissionHandler {
+	return mutateObject(func(ar *AdmissionReview) (runtime.Object, error) {
+		gvk := schema.GroupVersionKind{
+			Group:   ar.Request.Kind.Group,
+			Version: ar.Request.Kind.Version,
+			Kind:    ar.Request.Kind.Kind,
+		}
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(ar.Request.Object.Raw, &gvk, nil)
+		if runtime.IsNotRegisteredError(err) {
+			obj, _, err = unstructured.UnstructuredJSONScheme.Decode(ar.Request.Object.Raw, &gvk, nil)
+		}
+		return obj, err
+	}, mutate)
+}
+
+// MutateObjectInto is like MutateObject, decoding the object of the request in
+// the one returned by newObject whatever its kind
+func MutateObjectInto(newObject func() runtime.Object, mutate ObjectMutator) AdmissionHandler {
+	return mutateObject(func(ar *AdmissionReview) (runtime.Object, error) {
+		obj := newObject()
+		if err := json.Unmarshal(ar.Request.Object.Raw, obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}, mutate)
+}
+
+func mutateObject(decode func(*AdmissionReview) (runtime.Object, error), mutate ObjectMutator) AdmissionHandler {
+	return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+		if len(ar.Request.Object.Raw) == 0 {
+			// nothing to mutate (e.g. DELETE)
+			return &admissionV1.AdmissionResponse{Allowed: true}
+		}
+		obj, err := decode(ar)
+		if err != nil {
+			klog.Errorf("Could not decode raw object: %v", err)
+			return ErrorResponse(http.StatusBadRequest, metav1.StatusReasonBadRequest,
+				fmt.Sprintf("could not decode object: %v", err))
+		}
+		original, err := json.Marshal(obj)
+		if err != nil {
+			return ErrorResponse(http.StatusInternalServerError, metav1.StatusReasonInternalError,
+				fmt.Sprintf("could not encode object: %v", err))
+		}
+
+		if err := mutate(ar, obj); err != nil {
+			return ErrorResponse(http.StatusForbidden, metav1.StatusReasonForbidden, err.Error())
+		}
+
+		modified, err := json.Marshal(obj)
+		if err != nil {
+			return ErrorResponse(http.StatusInternalServerError, metav1.StatusReasonInternalError,
+				fmt.Sprintf("could not encode mutated object: %v", err))
+		}
+		patch, err := createPatch(original, modified, ar.Request.Object.Raw)
+		if err != nil {
+			return ErrorResponse(http.StatusInternalServerError, metav1.StatusReasonInternalError,
+				fmt.Sprintf("could not compute patch: %v", err))
+		}
+		if len(patch) > 0 {
+			klog.V(2).Infof("Patching %s %s/%s: %v", ar.Request.Kind.Kind,
+				ar.Request.Namespace, ar.Request.Name, patch)
+		}
+		return PatchResponse(patch)
+	}
+}
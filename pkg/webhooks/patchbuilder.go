@@ -0,0 +1,342 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// JSONPointer returns the RFC 6901 pointer made of the given tokens, escaping
+// "~" and "/" in them (e.g. for annotation and label keys)
+func JSONPointer(tokens ...string) string {
+	var sb strings.Builder
+	for _, t := range tokens {
+		sb.WriteByte('/')
+		sb.WriteString(pointerEscaper.Replace(t))
+	}
+	return sb.String()
+}
+
+// PointerTokens splits an RFC 6901 pointer in its unescaped tokens
+func PointerTokens(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = pointerUnescaper.Replace(t)
+	}
+	return tokens, nil
+}
+
+// PatchBuilder builds the JSON Patch for a document, choosing between add and
+// replace and creating the missing parents as needed. The builder follows the
+// changes made by its operations, so they can be chained. The first error is
+// kept and returned by Operations, Marshal and Apply.
+type PatchBuilder struct {
+	original []byte
+	doc      interface{}
+	ops      []PatchOperation
+	err      error
+}
+
+// NewPatchBuilder returns a builder for the given JSON document
+func NewPatchBuilder(document []byte) (*PatchBuilder, error) {
+	pb := &PatchBuilder{original: document}
+	if err := decodeJSON(document, &pb.doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %v", err)
+	}
+	return pb, nil
+}
+
+// Set adds or replaces the value at the path given as tokens, creating the
+// missing parent objects
+func (pb *PatchBuilder) Set(value interface{}, tokens ...string) *PatchBuilder {
+	if len(tokens) == 0 {
+		return pb.fail(fmt.Errorf("can't set the whole document"))
+	}
+	pb.EnsureMap(tokens[:len(tokens)-1]...)
+	if pb.err != nil {
+		return pb
+	}
+	normalized, err := normalizeJSON(value)
+	if err != nil {
+		return pb.fail(err)
+	}
+	current, exists := pb.get(tokens)
+	if exists && jsonEqual(current, normalized) {
+		return pb
+	}
+	op := "add"
+	if exists {
+		op = "replace"
+	}
+	return pb.apply(PatchOperation{Op: op, Path: JSONPointer(tokens...), Value: value}, tokens, normalized)
+}
+
+// Remove removes the value at the path given as tokens, if any
+func (pb *PatchBuilder) Remove(tokens ...string) *PatchBuilder {
+	if pb.err != nil {
+		return pb
+	}
+	if _, exists := pb.get(tokens); !exists || len(tokens) == 0 {
+		return pb
+	}
+	return pb.apply(PatchOperation{Op: "remove", Path: JSONPointer(tokens...)}, tokens, nil)
+}
+
+// Append adds the value at the end of the array at the path given as tokens,
+// creating the array if it's missing
+func (pb *PatchBuilder) Append(value interface{}, tokens ...string) *PatchBuilder {
+	if pb.err != nil {
+		return pb
+	}
+	current, exists := pb.get(tokens)
+	if !exists || current == nil {
+		return pb.Set([]interface{}{value}, tokens...)
+	}
+	if _, ok := current.([]interface{}); !ok {
+		return pb.fail(fmt.Errorf("%s is not an array", JSONPointer(tokens...)))
+	}
+	normalized, err := normalizeJSON(value)
+	if err != nil {
+		return pb.fail(err)
+	}
+	return pb.apply(PatchOperation{Op: "add", Path: JSONPointer(tokens...) + "/-", Value: value},
+		append(append([]string{}, tokens...), "-"), normalized)
+}
+
+// Insert adds the value before the index of the array at the path given as
+// tokens, the index can be the length of the array to add it at the end
+func (pb *PatchBuilder) Insert(value interface{}, index int, tokens ...string) *PatchBuilder {
+	if pb.err != nil {
+		return pb
+	}
+	current, exists := pb.get(tokens)
+	if _, ok := current.([]interface{}); !exists || !ok {
+		return pb.fail(fmt.Errorf("%s is not an array", JSONPointer(tokens...)))
+	}
+	normalized, err := normalizeJSON(value)
+	if err != nil {
+		return pb.fail(err)
+	}
+	indexed := append(append([]string{}, tokens...), strconv.Itoa(index))
+	return pb.apply(PatchOperation{Op: "add", Path: JSONPointer(indexed...), Value: value}, indexed, normalized)
+}
+
+// EnsureMap creates the objects missing (or null) along the path given as tokens
+func (pb *PatchBuilder) EnsureMap(tokens ...string) *PatchBuilder {
+	for i := 1; i <= len(tokens) && pb.err == nil; i++ {
+		current, exists := pb.get(tokens[:i])
+		switch current.(type) {
+		case map[string]interface{}, []interface{}:
+			continue
+		}
+		if exists && current != nil {
+			return pb.fail(fmt.Errorf("%s is not an object", JSONPointer(tokens[:i]...)))
+		}
+		op := "add"
+		if exists {
+			op = "replace"
+		}
+		pb.apply(PatchOperation{Op: op, Path: JSONPointer(tokens[:i]...), Value: map[string]interface{}{}},
+			tokens[:i], map[string]interface{}{})
+	}
+	return pb
+}
+
+func (pb *PatchBuilder) SetAnnotation(key, value string) *PatchBuilder {
+	return pb.Set(value, "metadata", "annotations", key)
+}
+
+func (pb *PatchBuilder) RemoveAnnotation(key string) *PatchBuilder {
+	return pb.Remove("metadata", "annotations", key)
+}
+
+func (pb *PatchBuilder) SetLabel(key, value string) *PatchBuilder {
+	return pb.Set(value, "metadata", "labels", key)
+}
+
+func (pb *PatchBuilder) RemoveLabel(key string) *PatchBuilder {
+	return pb.Remove("metadata", "labels", key)
+}
+
+// Operations returns the operations built so far
+func (pb *PatchBuilder) Operations() ([]PatchOperation, error) {
+	return pb.ops, pb.err
+}
+
+// Marshal returns the JSON Patch built so far
+func (pb *PatchBuilder) Marshal() ([]byte, error) {
+	if pb.err != nil {
+		return nil, pb.err
+	}
+	return json.Marshal(pb.ops)
+}
+
+// Apply applies the JSON Patch to the original document, to verify it
+func (pb *PatchBuilder) Apply() ([]byte, error) {
+	if pb.err != nil {
+		return nil, pb.err
+	}
+	return ApplyPatch(pb.original, pb.ops)
+}
+
+// Response admits the request with the JSON Patch built so far
+func (pb *PatchBuilder) Response() *admissionV1.AdmissionResponse {
+	if pb.err != nil {
+		return ErrorResponse(http.StatusInternalServerError, metav1.StatusReasonInternalError,
+			fmt.Sprintf("could not build patch: %v", pb.err))
+	}
+	return PatchResponse(pb.ops)
+}
+
+// ApplyPatch applies the operations to the JSON document
+func ApplyPatch(document []byte, ops []PatchOperation) ([]byte, error) {
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.DecodePatch(data)
+	if err != nil {
+		return nil, err
+	}
+	return patch.Apply(document)
+}
+
+func (pb *PatchBuilder) fail(err error) *PatchBuilder {
+	if pb.err == nil {
+		pb.err = err
+	}
+	return pb
+}
+
+// apply records the operation and reflects it on the document
+func (pb *PatchBuilder) apply(op PatchOperation, tokens []string, value interface{}) *PatchBuilder {
+	if pb.err != nil {
+		return pb
+	}
+	parent, exists := pb.get(tokens[:len(tokens)-1])
+	if !exists {
+		return pb.fail(fmt.Errorf("missing parent for %s", op.Path))
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		if op.Op == "remove" {
+			delete(p, last)
+		} else {
+			p[last] = value
+		}
+	case []interface{}:
+		var updated []interface{}
+		if last == "-" {
+			updated = append(p, value)
+		} else {
+			// add inserts before the index, so it can be the length of the array
+			size := len(p)
+			if op.Op == "add" {
+				size++
+			}
+			i, err := strconv.Atoi(last)
+			if err != nil || i < 0 || i >= size {
+				return pb.fail(fmt.Errorf("invalid index in %s", op.Path))
+			}
+			switch op.Op {
+			case "add":
+				updated = append(p[:i:i], append([]interface{}{value}, p[i:]...)...)
+			case "remove":
+				updated = append(p[:i:i], p[i+1:]...)
+			default:
+				p[i] = value
+				updated = p
+			}
+		}
+		// arrays are values, set back the grown or shrunk one
+		pb.setArray(tokens[:len(tokens)-1], updated)
+	default:
+		return pb.fail(fmt.Errorf("parent of %s is not an object or an array", op.Path))
+	}
+	pb.ops = append(pb.ops, op)
+	return pb
+}
+
+func (pb *PatchBuilder) setArray(tokens []string, array []interface{}) {
+	if len(tokens) == 0 {
+		pb.doc = array
+		return
+	}
+	parent, _ := pb.get(tokens[:len(tokens)-1])
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = array
+	case []interface{}:
+		if i, err := strconv.Atoi(last); err == nil {
+			p[i] = array
+		}
+	}
+}
+
+func (pb *PatchBuilder) get(tokens []string) (interface{}, bool) {
+	return lookupPointer(pb.doc, tokens)
+}
+
+// lookupPointer returns the value of the document at the path given as tokens
+func lookupPointer(doc interface{}, tokens []string) (interface{}, bool) {
+	current := doc
+	for _, t := range tokens {
+		switch c := current.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, false
+			}
+			current = c[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func decodeJSON(data []byte, into *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(into)
+}
+
+// normalizeJSON turns the value in its generic JSON representation
+func normalizeJSON(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	err = decodeJSON(data, &normalized)
+	return normalized, err
+}
+
+func jsonEqual(a, b interface{}) bool {
+	aData, aErr := json.Marshal(a)
+	bData, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aData, bData)
+}
@@ -0,0 +1,225 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+func TestJSONPointer(t *testing.T) {
+	tests := []struct {
+		tokens  []string
+		pointer string
+	}{
+		{tokens: nil, pointer: ""},
+		{tokens: []string{"metadata", "annotations", "nginx.ingress.kubernetes.io/rewrite-target"},
+			pointer: "/metadata/annotations/nginx.ingress.kubernetes.io~1rewrite-target"},
+		{tokens: []string{"a~b", "~1"}, pointer: "/a~0b/~01"},
+		{tokens: []string{""}, pointer: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pointer, func(t *testing.T) {
+			if got := JSONPointer(tt.tokens...); got != tt.pointer {
+				t.Errorf("JSONPointer(%q) = %s, want %s", tt.tokens, got, tt.pointer)
+			}
+			tokens, err := PointerTokens(tt.pointer)
+			if err != nil {
+				t.Fatalf("PointerTokens(%s) error = %v", tt.pointer, err)
+			}
+			if !reflect.DeepEqual(tokens, tt.tokens) {
+				t.Errorf("PointerTokens(%s) = %q, want %q", tt.pointer, tokens, tt.tokens)
+			}
+		})
+	}
+	if _, err := PointerTokens("metadata"); err == nil {
+		t.Error("PointerTokens() of a pointer not starting with / should fail")
+	}
+}
+
+func TestPatchBuilder(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		build   func(pb *PatchBuilder) *PatchBuilder
+		want    string
+		wantOps []PatchOperation
+		wantErr bool
+	}{
+		{
+			name:    "escaped annotation key",
+			doc:     `{"metadata":{"annotations":{"a~b":"x"}}}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.SetAnnotation("a~b", "y").SetAnnotation("a/b", "z") },
+			want:    `{"metadata":{"annotations":{"a~b":"y","a/b":"z"}}}`,
+			wantOps: []PatchOperation{{Op: "replace", Path: "/metadata/annotations/a~0b", Value: "y"}, {Op: "add", Path: "/metadata/annotations/a~1b", Value: "z"}},
+		},
+		{
+			name:  "missing parents",
+			doc:   `{"metadata":{"name":"web"}}`,
+			build: func(pb *PatchBuilder) *PatchBuilder { return pb.SetLabel("app", "web") },
+			want:  `{"metadata":{"name":"web","labels":{"app":"web"}}}`,
+			wantOps: []PatchOperation{
+				{Op: "add", Path: "/metadata/labels", Value: map[string]interface{}{}},
+				{Op: "add", Path: "/metadata/labels/app", Value: "web"},
+			},
+		},
+		{
+			name:  "null parent",
+			doc:   `{"metadata":{"labels":null}}`,
+			build: func(pb *PatchBuilder) *PatchBuilder { return pb.SetLabel("app", "web") },
+			want:  `{"metadata":{"labels":{"app":"web"}}}`,
+			wantOps: []PatchOperation{
+				{Op: "replace", Path: "/metadata/labels", Value: map[string]interface{}{}},
+				{Op: "add", Path: "/metadata/labels/app", Value: "web"},
+			},
+		},
+		{
+			name:  "unchanged value",
+			doc:   `{"spec":{"replicas":3}}`,
+			build: func(pb *PatchBuilder) *PatchBuilder { return pb.Set(3, "spec", "replicas") },
+			want:  `{"spec":{"replicas":3}}`,
+		},
+		{
+			name:    "remove missing value",
+			doc:     `{"metadata":{}}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.RemoveLabel("app") },
+			want:    `{"metadata":{}}`,
+			wantOps: nil,
+		},
+		{
+			name:    "array append",
+			doc:     `{"items":[1,2]}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Append(3, "items").Append(4, "items") },
+			want:    `{"items":[1,2,3,4]}`,
+			wantOps: []PatchOperation{{Op: "add", Path: "/items/-", Value: 3}, {Op: "add", Path: "/items/-", Value: 4}},
+		},
+		{
+			name:    "array append creates the array",
+			doc:     `{}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Append("a", "items") },
+			want:    `{"items":["a"]}`,
+			wantOps: []PatchOperation{{Op: "add", Path: "/items", Value: []interface{}{"a"}}},
+		},
+		{
+			name:    "array add inserts",
+			doc:     `{"items":[1,2,3]}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Insert(0, 0, "items").Insert(9, 2, "items") },
+			want:    `{"items":[0,1,9,2,3]}`,
+			wantOps: []PatchOperation{{Op: "add", Path: "/items/0", Value: 0}, {Op: "add", Path: "/items/2", Value: 9}},
+		},
+		{
+			name:    "array add at the length",
+			doc:     `{"items":[1,2]}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Insert(3, 2, "items").Set(4, "items", "3") },
+			want:    `{"items":[1,2,3,4]}`,
+			wantOps: []PatchOperation{{Op: "add", Path: "/items/2", Value: 3}, {Op: "add", Path: "/items/3", Value: 4}},
+		},
+		{
+			name:    "array add past the length",
+			doc:     `{"items":[1,2]}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Insert(3, 3, "items") },
+			wantErr: true,
+		},
+		{
+			name:    "array replace",
+			doc:     `{"items":[1,2,3]}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Set(5, "items", "1") },
+			want:    `{"items":[1,5,3]}`,
+			wantOps: []PatchOperation{{Op: "replace", Path: "/items/1", Value: 5}},
+		},
+		{
+			name:    "array remove",
+			doc:     `{"items":[1,2,3]}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Remove("items", "0").Remove("items", "1") },
+			want:    `{"items":[2]}`,
+			wantOps: []PatchOperation{{Op: "remove", Path: "/items/0"}, {Op: "remove", Path: "/items/1"}},
+		},
+		{
+			name: "nested array",
+			doc:  `{"spec":{"containers":[{"name":"a"},{"name":"b"}]}}`,
+			build: func(pb *PatchBuilder) *PatchBuilder {
+				return pb.Insert(map[string]string{"name": "init"}, 0, "spec", "containers").
+					Set("nginx", "spec", "containers", "2", "image")
+			},
+			want: `{"spec":{"containers":[{"name":"init"},{"name":"a"},{"name":"b","image":"nginx"}]}}`,
+			wantOps: []PatchOperation{
+				{Op: "add", Path: "/spec/containers/0", Value: map[string]string{"name": "init"}},
+				{Op: "add", Path: "/spec/containers/2/image", Value: "nginx"},
+			},
+		},
+		{
+			name:    "append to an object",
+			doc:     `{"items":{}}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Append(1, "items") },
+			wantErr: true,
+		},
+		{
+			name:    "parent not an object",
+			doc:     `{"metadata":"x"}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.SetLabel("app", "web") },
+			wantErr: true,
+		},
+		{
+			name:    "the first error is kept",
+			doc:     `{"metadata":{}}`,
+			build:   func(pb *PatchBuilder) *PatchBuilder { return pb.Set(1).SetLabel("app", "web") },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pb, err := NewPatchBuilder([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ops, err := tt.build(pb).Operations()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Operations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if resp := pb.Response(); resp.Allowed {
+					t.Error("Response() of a failed builder should not admit the request")
+				}
+				return
+			}
+			if !reflect.DeepEqual(ops, tt.wantOps) {
+				t.Errorf("operations = %+v, want %+v", ops, tt.wantOps)
+			}
+
+			// the patch gives the same document with evanphx/json-patch
+			patch, err := pb.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := jsonpatch.DecodePatch(patch)
+			if err != nil {
+				t.Fatalf("DecodePatch(%s) error = %v", patch, err)
+			}
+			patched, err := decoded.Apply([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("Apply(%s) error = %v", patch, err)
+			}
+			if !jsonpatch.Equal(patched, []byte(tt.want)) {
+				t.Errorf("patched document = %s, want %s", patched, tt.want)
+			}
+			// and the builder followed the changes
+			followed, err := json.Marshal(pb.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonpatch.Equal(followed, patched) {
+				t.Errorf("document of the builder = %s, want %s", followed, patched)
+			}
+			if applied, err := pb.Apply(); err != nil || !jsonpatch.Equal(applied, patched) {
+				t.Errorf("Apply() = %s, %v, want %s", applied, err, patched)
+			}
+		})
+	}
+}
+
+func TestNewPatchBuilderInvalidJSON(t *testing.T) {
+	if _, err := NewPatchBuilder([]byte(`{"metadata":`)); err == nil {
+		t.Error("NewPatchBuilder() of invalid JSON should fail")
+	}
+}
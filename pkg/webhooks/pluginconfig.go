@@ -0,0 +1,206 @@
+package webhooks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// PluginConfig is the typed configuration of a plugin, a pointer to a struct
+// with yaml tags
+type PluginConfig interface {
+	// Validate checks the values, it can also prepare derived ones (e.g.
+	// parsed selectors) kept in unexported fields
+	Validate() error
+}
+
+// ConfigSection is the configuration of a plugin, read from a key of the
+// ConfigMap of the server. The key is decoded strictly over the defaults, so
+// the missing fields keep their default value and the unknown ones are an
+// error, then validated. An invalid update is rejected as a whole and the last
+// valid configuration stays in effect.
+type ConfigSection struct {
+	Key string
+
+	defaults func() PluginConfig
+	fallback func(data map[string]string, cfg PluginConfig) error
+	legacy   func(data string, cfg PluginConfig) error
+	current  atomic.Value // PluginConfig
+
+	lock sync.Mutex
+	err  error
+}
+
+// NewConfigSection returns the section of the key, with the configuration
+// returned by defaults in effect. It panics when the defaults are invalid.
+func NewConfigSection(key string, defaults func() PluginConfig) *ConfigSection {
+	cfg := defaults()
+	if err := cfg.Validate(); err != nil {
+		panic(fmt.Sprintf("webhooks: invalid default config for %s: %v", key, err))
+	}
+	s := &ConfigSection{Key: key, defaults: defaults}
+	s.current.Store(cfg)
+	return s
+}
+
+// WithFallback decodes the configuration from the other keys of the ConfigMap
+// when the key is missing, e.g. the keys of a former format
+func (s *ConfigSection) WithFallback(decode func(data map[string]string, cfg PluginConfig) error) *ConfigSection {
+	s.fallback = decode
+	return s
+}
+
+// WithLegacyFormat decodes the key with decode when it doesn't decode
+// strictly, e.g. a former format with quoted numbers. The configuration
+// decoded so is validated the same way.
+func (s *ConfigSection) WithLegacyFormat(decode func(data string, cfg PluginConfig) error) *ConfigSection {
+	s.legacy = decode
+	return s
+}
+
+// Current returns the configuration in effect, it must not be modified
+func (s *ConfigSection) Current() PluginConfig {
+	return s.current.Load().(PluginConfig)
+}
+
+// Err returns why the last update was rejected, nil when it was applied
+func (s *ConfigSection) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Update decodes the YAML over the defaults, the defaults alone when empty
+func (s *ConfigSection) Update(data string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cfg := s.defaults()
+	err := DecodeStrict(data, cfg)
+	if err != nil && s.legacy != nil {
+		// over fresh defaults, the strict decoding may have set some fields
+		legacyCfg := s.defaults()
+		if s.legacy(data, legacyCfg) == nil {
+			klog.Warningf("%s decoded in its legacy format: %v", s.Key, err)
+			cfg, err = legacyCfg, nil
+		}
+	}
+	return s.set(cfg, err)
+}
+
+// Apply sets the configuration made by decode over the defaults, if valid
+func (s *ConfigSection) Apply(decode func(PluginConfig) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cfg := s.defaults()
+	return s.set(cfg, decode(cfg))
+}
+
+// set puts the decoded configuration in effect if valid, with the lock held
+func (s *ConfigSection) set(cfg PluginConfig, err error) error {
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		s.err = errors.New(fmt.Sprintf("invalid %s: %v", s.Key, err))
+		return s.err
+	}
+	s.err = nil
+	s.current.Store(cfg)
+	return nil
+}
+
+// UpdateFromConfigMap updates the configuration from the key of the
+// ConfigMap, from the fallback or back to the defaults when it's missing
+func (s *ConfigSection) UpdateFromConfigMap(cm *corev1.ConfigMap) error {
+	data, found := cm.Data[s.Key]
+	if !found && s.fallback != nil {
+		return s.Apply(func(cfg PluginConfig) error {
+			return s.fallback(cm.Data, cfg)
+		})
+	}
+	return s.Update(data)
+}
+
+// DecodeStrict decodes the YAML into out, rejecting the unknown fields
+func DecodeStrict(data string, out interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewBufferString(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// watchedSection is a section kept updated by an informer
+type watchedSection struct {
+	informer cache.SharedIndexInformer
+	section  *ConfigSection
+}
+
+var (
+	watchedSectionsLock sync.Mutex
+	watchedSections     = make(map[watchedSection]bool)
+)
+
+// WatchConfigSection loads the section from the ConfigMap of the server and
+// keeps it updated, the rejected updates are logged and kept in Err. The
+// event handler is added once per section, so a plugin set up again (e.g.
+// disabled and enabled from the ConfigMap) doesn't stack another one.
+func WatchConfigSection(server WebhookServer, section *ConfigSection) error {
+	config := server.GetConfig()
+	cs, err := server.KubeClient()
+	if err != nil {
+		return err
+	}
+	f, err := server.ScopedFactory(config.ConfigMapScope())
+	if err != nil {
+		return err
+	}
+	update := func(obj interface{}) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return
+		}
+		if err := section.UpdateFromConfigMap(cm); err != nil {
+			klog.Errorf("Rejected the update of ConfigMap %s/%s, keeping the last valid config: %v",
+				cm.Namespace, cm.Name, err)
+		}
+	}
+	// get initial values from CM
+	if cm, err := cs.CoreV1().ConfigMaps(config.CmNamespace).
+		Get(config.CmName, metav1.GetOptions{}); err == nil {
+		update(cm)
+	}
+	informer := f.Core().V1().ConfigMaps().Informer()
+	watchedSectionsLock.Lock()
+	defer watchedSectionsLock.Unlock()
+	if watchedSections[watchedSection{informer, section}] {
+		return nil
+	}
+	watchedSections[watchedSection{informer, section}] = true
+	informer.AddEventHandler(
+		cache.FilteringResourceEventHandler{
+			FilterFunc: func(obj interface{}) bool {
+				cm, ok := obj.(*corev1.ConfigMap)
+				return ok &&
+					cm.ObjectMeta.Namespace == config.CmNamespace &&
+					cm.ObjectMeta.Name == config.CmName
+			},
+			Handler: cache.ResourceEventHandlerFuncs{
+				AddFunc: update,
+				UpdateFunc: func(old interface{}, new interface{}) {
+					update(new)
+				},
+			},
+		})
+	return nil
+}
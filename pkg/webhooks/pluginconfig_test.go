@@ -0,0 +1,197 @@
+package webhooks
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type testConfig struct {
+	Replicas int    `yaml:"replicas"`
+	Topology string `yaml:"topology"`
+}
+
+func (c *testConfig) Validate() error {
+	if c.Replicas < 1 {
+		return errors.New("replicas is less than 1")
+	}
+	return nil
+}
+
+func newTestConfigSection() *ConfigSection {
+	return NewConfigSection("test", func() PluginConfig {
+		return &testConfig{Replicas: 3, Topology: "zone"}
+	})
+}
+
+func TestConfigSectionUpdate(t *testing.T) {
+	valid := &testConfig{Replicas: 5, Topology: "zone"}
+	tests := []struct {
+		name    string
+		data    string
+		want    *testConfig
+		wantErr bool
+	}{
+		{name: "defaults when empty", data: "", want: &testConfig{Replicas: 3, Topology: "zone"}},
+		{name: "missing fields keep the defaults", data: "replicas: 1\n", want: &testConfig{Replicas: 1, Topology: "zone"}},
+		{name: "all fields", data: "replicas: 2\ntopology: host\n", want: &testConfig{Replicas: 2, Topology: "host"}},
+		{name: "unknown field", data: "replicas: 2\nreplica: 3\n", want: valid, wantErr: true},
+		{name: "wrong type", data: "replicas: abc\n", want: valid, wantErr: true},
+		{name: "invalid value", data: "replicas: 0\n", want: valid, wantErr: true},
+		{name: "invalid YAML", data: "replicas: [\n", want: valid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConfigSection()
+			if err := s.Update("replicas: 5\n"); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.Update(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if s.Err() != err {
+				t.Errorf("Err() = %v, want %v", s.Err(), err)
+			}
+			if got := s.Current(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Current() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigSectionApply(t *testing.T) {
+	s := newTestConfigSection()
+	before := s.Current()
+
+	// an invalid update is rejected as a whole, even if decode set fields
+	err := s.Apply(func(cfg PluginConfig) error {
+		cfg.(*testConfig).Topology = "host"
+		return errors.New("decode failed")
+	})
+	if err == nil {
+		t.Fatal("Apply() of a failing decode should fail")
+	}
+	if s.Current() != before {
+		t.Errorf("Current() = %+v, want the previous config %+v", s.Current(), before)
+	}
+
+	// decode works on a fresh copy of the defaults
+	if err := s.Apply(func(cfg PluginConfig) error {
+		if got := cfg.(*testConfig).Topology; got != "zone" {
+			t.Errorf("decoded over topology %q, want the default", got)
+		}
+		cfg.(*testConfig).Replicas = 7
+		return nil
+	}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if s.Err() != nil {
+		t.Errorf("Err() = %v after a valid update", s.Err())
+	}
+	if got := s.Current().(*testConfig).Replicas; got != 7 {
+		t.Errorf("Current().Replicas = %d, want 7", got)
+	}
+	if before.(*testConfig).Replicas != 3 {
+		t.Error("the previous config was modified")
+	}
+}
+
+func TestConfigSectionUpdateFromConfigMap(t *testing.T) {
+	legacy := func(data map[string]string, cfg PluginConfig) error {
+		if v, found := data["legacyTopology"]; found {
+			cfg.(*testConfig).Topology = v
+		}
+		return nil
+	}
+	tests := []struct {
+		name     string
+		data     map[string]string
+		fallback bool
+		want     *testConfig
+	}{
+		{
+			name: "key",
+			data: map[string]string{"test": "replicas: 4\n", "legacyTopology": "host"},
+			want: &testConfig{Replicas: 4, Topology: "zone"},
+		},
+		{
+			name:     "fallback without the key",
+			data:     map[string]string{"legacyTopology": "host"},
+			fallback: true,
+			want:     &testConfig{Replicas: 3, Topology: "host"},
+		},
+		{
+			name: "defaults without the key nor a fallback",
+			data: map[string]string{"legacyTopology": "host"},
+			want: &testConfig{Replicas: 3, Topology: "zone"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConfigSection()
+			if tt.fallback {
+				s.WithFallback(legacy)
+			}
+			s.Update("replicas: 9\n")
+			if err := s.UpdateFromConfigMap(&corev1.ConfigMap{Data: tt.data}); err != nil {
+				t.Fatalf("UpdateFromConfigMap() error = %v", err)
+			}
+			if got := s.Current(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Current() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewConfigSectionInvalidDefaults(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewConfigSection() with invalid defaults should panic")
+		}
+	}()
+	NewConfigSection("test", func() PluginConfig { return &testConfig{} })
+}
+
+func TestConfigSectionLegacyFormat(t *testing.T) {
+	// the legacy format has the replicas as a word
+	legacy := func(data string, cfg PluginConfig) error {
+		switch data {
+		case "replicas: two\n":
+			cfg.(*testConfig).Replicas = 2
+		case "replicas: zero\n":
+			cfg.(*testConfig).Replicas = 0
+		default:
+			return errors.New("not the legacy format")
+		}
+		return nil
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    *testConfig
+		wantErr bool
+	}{
+		{name: "strict format", data: "replicas: 4\ntopology: host\n", want: &testConfig{Replicas: 4, Topology: "host"}},
+		{name: "legacy format", data: "replicas: two\n", want: &testConfig{Replicas: 2, Topology: "zone"}},
+		{name: "invalid legacy value", data: "replicas: zero\n", want: &testConfig{Replicas: 5, Topology: "zone"}, wantErr: true},
+		{name: "neither format", data: "replicas: many\n", want: &testConfig{Replicas: 5, Topology: "zone"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestConfigSection().WithLegacyFormat(legacy)
+			if err := s.Update("replicas: 5\n"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Update(tt.data); (err != nil) != tt.wantErr {
+				t.Fatalf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := s.Current(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Current() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
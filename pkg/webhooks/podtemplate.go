@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// pointers to the pod template of the workload kinds, empty for the Pod itself
+var podTemplatePaths = map[schema.GroupKind]string{
+	{Group: "", Kind: "Pod"}:                   "",
+	{Group: "", Kind: "ReplicationController"}: "/spec/template",
+	{Group: "apps", Kind: "Deployment"}:        "/spec/template",
+	{Group: "apps", Kind: "ReplicaSet"}:        "/spec/template",
+	{Group: "apps", Kind: "StatefulSet"}:       "/spec/template",
+	{Group: "apps", Kind: "DaemonSet"}:         "/spec/template",
+	{Group: "extensions", Kind: "Deployment"}:  "/spec/template",
+	{Group: "extensions", Kind: "ReplicaSet"}:  "/spec/template",
+	{Group: "extensions", Kind: "DaemonSet"}:   "/spec/template",
+	{Group: "batch", Kind: "Job"}:              "/spec/template",
+	{Group: "batch", Kind: "CronJob"}:          "/spec/jobTemplate/spec/template",
+}
+
+// PodTemplate gives access to the pods of the object of the request
+type PodTemplate struct {
+	Kind string
+	// Object is the metadata of the object, the same as Metadata for a Pod
+	Object *metav1.ObjectMeta
+	// Metadata and Spec of the pods
+	Metadata *metav1.ObjectMeta
+	Spec     *corev1.PodSpec
+}
+
+// PodTemplateMutator changes in place the pods of the object of the request,
+// returning an error denies the request
+type PodTemplateMutator func(ar *AdmissionReview, pt *PodTemplate) error
+
+// podTemplatePart is a subtree of the object decoded for the mutator
+type podTemplatePart struct {
+	path string
+	into interface{}
+}
+
+// PodTemplatePath returns the JSON pointer of the pod template of the kind,
+// empty for a Pod, and false for the kinds without pods
+func PodTemplatePath(gk schema.GroupKind) (string, bool) {
+	path, ok := podTemplatePaths[gk]
+	return path, ok
+}
+
+// PodTemplateMatch matches the kinds supported by MutatePodTemplate
+func PodTemplateMatch() Match {
+	return Match{
+		Kinds: []metav1.GroupVersionKind{
+			{Group: "", Version: MatchAll, Kind: "Pod"},
+			{Group: "", Version: MatchAll, Kind: "ReplicationController"},
+			{Group: "apps", Version: MatchAll, Kind: "Deployment"},
+			{Group: "apps", Version: MatchAll, Kind: "ReplicaSet"},
+			{Group: "apps", Version: MatchAll, Kind: "StatefulSet"},
+			{Group: "apps", Version: MatchAll, Kind: "DaemonSet"},
+			{Group: "extensions", Version: MatchAll, Kind: "Deployment"},
+			{Group: "extensions", Version: MatchAll, Kind: "ReplicaSet"},
+			{Group: "extensions", Version: MatchAll, Kind: "DaemonSet"},
+			{Group: "batch", Version: MatchAll, Kind: "Job"},
+			{Group: "batch", Version: MatchAll, Kind: "CronJob"},
+		},
+	}
+}
+
+// MutatePodTemplate runs the mutator on the pod template of Pods, Deployments,
+// ReplicaSets, StatefulSets, DaemonSets, Jobs, CronJobs and
+// ReplicationControllers, whatever their API version, and answers with the
+// JSON Patch of the changes. Other kinds are admitted unchanged.
+func MutatePodTemplate(mutate PodTemplateMutator) AdmissionHandler {
+	return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+		gk := schema.GroupKind{Group: ar.Request.Kind.Group, Kind: ar.Request.Kind.Kind}
+		prefix, ok := podTemplatePaths[gk]
+		if !ok || len(ar.Request.Object.Raw) == 0 {
+			return &admissionV1.AdmissionResponse{Allowed: true}
+		}
+		var doc interface{}
+		if err := decodeJSON(ar.Request.Object.Raw, &doc); err != nil {
+			klog.Errorf("Could not decode raw object: %v", err)
+			return ErrorResponse(http.StatusBadRequest, metav1.StatusReasonBadRequest,
+				fmt.Sprintf("could not decode object: %v", err))
+		}
+
+		pt := &PodTemplate{
+			Kind:   ar.Request.Kind.Kind,
+			Object: &metav1.ObjectMeta{},
+			Spec:   &corev1.PodSpec{},
+		}
+		parts := []podTemplatePart{{"/metadata", pt.Object}}
+		if prefix == "" {
+			pt.Metadata = pt.Object
+		} else {
+			pt.Metadata = &metav1.ObjectMeta{}
+			parts = append(parts, podTemplatePart{prefix + "/metadata", pt.Metadata})
+		}
+		parts = append(parts, podTemplatePart{prefix + "/spec", pt.Spec})
+
+		originals := make([][]byte, len(parts))
+		for i, part := range parts {
+			tokens, _ := PointerTokens(part.path)
+			if sub, exists := lookupPointer(doc, tokens); exists {
+				data, _ := json.Marshal(sub)
+				if err := json.Unmarshal(data, part.into); err != nil {
+					klog.Errorf("Could not decode %s: %v", part.path, err)
+					return ErrorResponse(http.StatusBadRequest, metav1.StatusReasonBadRequest,
+						fmt.Sprintf("could not decode %s: %v", part.path, err))
+				}
+			}
+			originals[i], _ = json.Marshal(part.into)
+		}
+
+		if err := mutate(ar, pt); err != nil {
+			return ErrorResponse(http.StatusForbidden, metav1.StatusReasonForbidden, err.Error())
+		}
+
+		var ops []PatchOperation
+		for i, part := range parts {
+			modified, err := json.Marshal(part.into)
+			if err != nil {
+				return ErrorResponse(http.StatusInternalServerError, metav1.StatusReasonInternalError,
+					fmt.Sprintf("could not encode %s: %v", part.path, err))
+			}
+			if bytes.Equal(originals[i], modified) {
+				continue
+			}
+			tokens, _ := PointerTokens(part.path)
+			sub, exists := lookupPointer(doc, tokens)
+			if !exists {
+				var value interface{}
+				if err := decodeJSON(modified, &value); err != nil {
+					return ErrorResponse(http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+				}
+				ops = append(ops, PatchOperation{Op: "add", Path: part.path, Value: value})
+				continue
+			}
+			raw, _ := json.Marshal(sub)
+			partOps, err := createPatch(originals[i], modified, raw)
+			if err != nil {
+				return ErrorResponse(http.StatusInternalServerError, metav1.StatusReasonInternalError,
+					fmt.Sprintf("could not compute patch: %v", err))
+			}
+			for _, op := range partOps {
+				op.Path = part.path + op.Path
+				ops = append(ops, op)
+			}
+		}
+		if len(ops) > 0 {
+			klog.V(2).Infof("Patching pod template of %s %s/%s: %v", ar.Request.Kind.Kind,
+				ar.Request.Namespace, ar.Request.Name, ops)
+		}
+		return PatchResponse(ops)
+	}
+}
@@ -0,0 +1,151 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// withPodSpec returns an object holding the pod spec at the pointer of its kind
+func withPodSpec(prefix string, spec string) string {
+	doc := `{"spec":` + spec + `}`
+	tokens, _ := PointerTokens(prefix)
+	for i := len(tokens) - 1; i >= 0; i-- {
+		doc = `{"` + tokens[i] + `":` + doc + `}`
+	}
+	return `{"metadata":{"name":"web"},` + doc[1:]
+}
+
+func TestPodTemplatePath(t *testing.T) {
+	tests := []struct {
+		gk     schema.GroupKind
+		want   string
+		wantOk bool
+	}{
+		{gk: schema.GroupKind{Group: "", Kind: "Pod"}, want: "", wantOk: true},
+		{gk: schema.GroupKind{Group: "", Kind: "ReplicationController"}, want: "/spec/template", wantOk: true},
+		{gk: schema.GroupKind{Group: "apps", Kind: "Deployment"}, want: "/spec/template", wantOk: true},
+		{gk: schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}, want: "/spec/template", wantOk: true},
+		{gk: schema.GroupKind{Group: "apps", Kind: "StatefulSet"}, want: "/spec/template", wantOk: true},
+		{gk: schema.GroupKind{Group: "apps", Kind: "DaemonSet"}, want: "/spec/template", wantOk: true},
+		{gk: schema.GroupKind{Group: "extensions", Kind: "Deployment"}, want: "/spec/template", wantOk: true},
+		{gk: schema.GroupKind{Group: "batch", Kind: "Job"}, want: "/spec/template", wantOk: true},
+		{gk: schema.GroupKind{Group: "batch", Kind: "CronJob"}, want: "/spec/jobTemplate/spec/template", wantOk: true},
+		{gk: schema.GroupKind{Group: "", Kind: "Deployment"}},
+		{gk: schema.GroupKind{Group: "example.com", Kind: "Deployment"}},
+		{gk: schema.GroupKind{Group: "apps", Kind: "Pod"}},
+		{gk: schema.GroupKind{Group: "", Kind: "Service"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gk.String(), func(t *testing.T) {
+			got, ok := PodTemplatePath(tt.gk)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("PodTemplatePath(%s) = %q, %v, want %q, %v", tt.gk, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestMutatePodTemplate(t *testing.T) {
+	setImage := MutatePodTemplate(func(ar *AdmissionReview, pt *PodTemplate) error {
+		pt.Object.Labels = map[string]string{"mutated": "true"}
+		pt.Spec.Containers[0].Image = "nginx:1.19"
+		if pt.Metadata.Annotations == nil {
+			pt.Metadata.Annotations = map[string]string{}
+		}
+		pt.Metadata.Annotations["pods"] = pt.Kind
+		return nil
+	})
+
+	tests := []struct {
+		kind      metav1.GroupVersionKind
+		wantSpec  string
+		unchanged bool
+	}{
+		{kind: metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"}, wantSpec: "/spec"},
+		{kind: metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "ReplicationController"}, wantSpec: "/spec/template/spec"},
+		{kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, wantSpec: "/spec/template/spec"},
+		{kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}, wantSpec: "/spec/template/spec"},
+		{kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, wantSpec: "/spec/template/spec"},
+		{kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, wantSpec: "/spec/template/spec"},
+		{kind: metav1.GroupVersionKind{Group: "extensions", Version: "v1beta1", Kind: "Deployment"}, wantSpec: "/spec/template/spec"},
+		{kind: metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, wantSpec: "/spec/template/spec"},
+		{kind: metav1.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"}, wantSpec: "/spec/jobTemplate/spec/template/spec"},
+		{kind: metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Deployment"}, unchanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind.Group+"/"+tt.kind.Kind, func(t *testing.T) {
+			prefix, _ := PodTemplatePath(schema.GroupKind{Group: tt.kind.Group, Kind: tt.kind.Kind})
+			object := withPodSpec(prefix, `{"containers":[{"name":"web","image":"nginx:1.18"}]}`)
+			if tt.unchanged {
+				object = withPodSpec("/spec/template", `{"containers":[{"name":"web","image":"nginx:1.18"}]}`)
+			}
+			resp := setImage(&AdmissionReview{Request: &admissionV1.AdmissionRequest{
+				UID:    "uid",
+				Kind:   tt.kind,
+				Object: raw(object),
+			}})
+			if !resp.Allowed {
+				t.Fatalf("response = %+v, want allowed", resp)
+			}
+			if tt.unchanged {
+				if len(resp.Patch) != 0 {
+					t.Errorf("patch = %s, want the object unchanged", resp.Patch)
+				}
+				return
+			}
+
+			var ops []PatchOperation
+			if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, op := range ops {
+				if op.Path == tt.wantSpec+"/containers/0/image" && op.Value == "nginx:1.19" {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("patch = %s, want the image replaced at %s", resp.Patch, tt.wantSpec)
+			}
+
+			patch, err := jsonpatch.DecodePatch(resp.Patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patched, err := patch.Apply([]byte(object))
+			if err != nil {
+				t.Fatalf("Apply(%s) error = %v", resp.Patch, err)
+			}
+			var doc interface{}
+			if err := decodeJSON(patched, &doc); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := lookupPointer(doc, []string{"metadata", "labels", "mutated"}); v != "true" {
+				t.Errorf("patched object = %s, want the label on the object", patched)
+			}
+			tokens, _ := PointerTokens(prefix + "/metadata/annotations/pods")
+			if v, _ := lookupPointer(doc, tokens); v != tt.kind.Kind {
+				t.Errorf("patched object = %s, want the annotation on the pods", patched)
+			}
+		})
+	}
+}
+
+func TestMutatePodTemplateDenied(t *testing.T) {
+	deny := MutatePodTemplate(func(*AdmissionReview, *PodTemplate) error {
+		return errors.New("image not allowed")
+	})
+	resp := deny(&AdmissionReview{Request: &admissionV1.AdmissionRequest{
+		Kind:   podKind,
+		Object: raw(`{"metadata":{"name":"web"},"spec":{}}`),
+	}})
+	if resp.Allowed || resp.Result == nil || resp.Result.Message != "image not allowed" {
+		t.Errorf("response = %+v, want denied with the error of the mutator", resp)
+	}
+}
@@ -0,0 +1,107 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AdmitPolicy is the answer to the requests no handler is registered or
+// matching for, and the failure policy of the handlers with
+// FailurePolicyDefault
+type AdmitPolicy string
+
+const (
+	AdmitPolicyAlways AdmitPolicy = "Always"
+	AdmitPolicyNever  AdmitPolicy = "Never"
+)
+
+// ParseAdmitPolicy accepts only Always and Never, with this exact case
+func ParseAdmitPolicy(policy string) (AdmitPolicy, error) {
+	switch p := AdmitPolicy(policy); p {
+	case AdmitPolicyAlways, AdmitPolicyNever:
+		return p, nil
+	}
+	return "", errors.New(fmt.Sprintf("invalid admit policy %q, expect %s or %s", policy, AdmitPolicyAlways, AdmitPolicyNever))
+}
+
+// AdmitPolicyRule overrides the default admit policy for the requests of a
+// path prefix and of a namespace, an empty one matching them all
+type AdmitPolicyRule struct {
+	Path      string      `yaml:"path"`
+	Namespace string      `yaml:"namespace"`
+	Policy    AdmitPolicy `yaml:"policy"`
+}
+
+func (r *AdmitPolicyRule) String() string {
+	var scope []string
+	if r.Path != "" {
+		scope = append(scope, "path "+r.Path)
+	}
+	if r.Namespace != "" {
+		scope = append(scope, "namespace "+r.Namespace)
+	}
+	return fmt.Sprintf("admit policy %s of %s", r.Policy, strings.Join(scope, " in "))
+}
+
+func (r *AdmitPolicyRule) validate() error {
+	if _, err := ParseAdmitPolicy(string(r.Policy)); err != nil {
+		return err
+	}
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+		return errors.New(fmt.Sprintf("path %s of admit policy rule doesn't start with /", r.Path))
+	}
+	if r.Path == "" && r.Namespace == "" {
+		return errors.New("admit policy rule without path nor namespace, use the default admit policy")
+	}
+	return nil
+}
+
+// ParseAdmitPolicyRules parses a YAML list of rules, made of path, namespace
+// and policy fields, rejecting unknown fields and invalid policies
+func ParseAdmitPolicyRules(rulesYAML string) ([]AdmitPolicyRule, error) {
+	var rules []AdmitPolicyRule
+	if err := DecodeStrict(rulesYAML, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("rule %d: %v", i, err))
+		}
+	}
+	return rules, nil
+}
+
+// AdmitPolicies is the default admit policy with its overrides, the first
+// rule matching the path and the namespace of a request wins
+type AdmitPolicies struct {
+	Default AdmitPolicy
+	Rules   []AdmitPolicyRule
+}
+
+// NewAdmitPolicies validates the default policy and the rules
+func NewAdmitPolicies(defaultPolicy string, rules []AdmitPolicyRule) (*AdmitPolicies, error) {
+	policy, err := ParseAdmitPolicy(defaultPolicy)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("rule %d: %v", i, err))
+		}
+	}
+	return &AdmitPolicies{Default: policy, Rules: rules}, nil
+}
+
+// For returns the policy applying to the path and the namespace, along with
+// the rule it comes from, nil for the default policy
+func (ap *AdmitPolicies) For(path, namespace string) (AdmitPolicy, *AdmitPolicyRule) {
+	for i := range ap.Rules {
+		r := &ap.Rules[i]
+		if (r.Path == "" || strings.HasPrefix(path, r.Path)) &&
+			(r.Namespace == "" || r.Namespace == namespace) {
+			return r.Policy, r
+		}
+	}
+	return ap.Default, nil
+}
@@ -0,0 +1,158 @@
+package webhooks
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAdmitPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    AdmitPolicy
+		wantErr bool
+	}{
+		{policy: "Always", want: AdmitPolicyAlways},
+		{policy: "Never", want: AdmitPolicyNever},
+		{policy: "never", wantErr: true},
+		{policy: "ALWAYS", wantErr: true},
+		{policy: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got, err := ParseAdmitPolicy(tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAdmitPolicy(%q) error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAdmitPolicy(%q) = %q, want %q", tt.policy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAdmitPolicyRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    []AdmitPolicyRule
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			yaml: "",
+		},
+		{
+			name: "rules",
+			yaml: `
+- path: /validate
+  namespace: kube-system
+  policy: Never
+- namespace: payments
+  policy: Never
+- path: /mutate
+  policy: Always
+`,
+			want: []AdmitPolicyRule{
+				{Path: "/validate", Namespace: "kube-system", Policy: AdmitPolicyNever},
+				{Namespace: "payments", Policy: AdmitPolicyNever},
+				{Path: "/mutate", Policy: AdmitPolicyAlways},
+			},
+		},
+		{
+			name:    "invalid policy case",
+			yaml:    "- path: /validate\n  policy: never\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing policy",
+			yaml:    "- path: /validate\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			yaml:    "- path: /validate\n  policy: Never\n  namespaces: [a]\n",
+			wantErr: true,
+		},
+		{
+			name:    "relative path",
+			yaml:    "- path: validate\n  policy: Never\n",
+			wantErr: true,
+		},
+		{
+			name:    "neither path nor namespace",
+			yaml:    "- policy: Never\n",
+			wantErr: true,
+		},
+		{
+			name:    "not a list",
+			yaml:    "path: /validate\npolicy: Never\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAdmitPolicyRules(tt.yaml)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAdmitPolicyRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAdmitPolicyRules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAdmitPolicies(t *testing.T) {
+	if _, err := NewAdmitPolicies("never", nil); err == nil {
+		t.Error("NewAdmitPolicies() with an invalid default policy should fail")
+	}
+	if _, err := NewAdmitPolicies("Always", []AdmitPolicyRule{{Path: "/a", Policy: "Sometimes"}}); err == nil {
+		t.Error("NewAdmitPolicies() with an invalid rule should fail")
+	}
+	if _, err := NewAdmitPolicies("Always", []AdmitPolicyRule{{Path: "/a", Policy: AdmitPolicyNever}}); err != nil {
+		t.Errorf("NewAdmitPolicies() error = %v", err)
+	}
+}
+
+func TestAdmitPoliciesFor(t *testing.T) {
+	ap := &AdmitPolicies{
+		Default: AdmitPolicyAlways,
+		Rules: []AdmitPolicyRule{
+			{Path: "/validate", Namespace: "kube-system", Policy: AdmitPolicyNever},
+			{Path: "/validate/pods", Policy: AdmitPolicyAlways},
+			{Namespace: "payments", Policy: AdmitPolicyNever},
+			{Path: "/validate", Policy: AdmitPolicyNever},
+		},
+	}
+	tests := []struct {
+		name      string
+		path      string
+		namespace string
+		want      AdmitPolicy
+		wantRule  int // index of the rule, -1 for the default policy
+	}{
+		{name: "path and namespace", path: "/validate/pods", namespace: "kube-system", want: AdmitPolicyNever, wantRule: 0},
+		{name: "first matching rule wins", path: "/validate/pods", namespace: "payments", want: AdmitPolicyAlways, wantRule: 1},
+		{name: "namespace only", path: "/mutate", namespace: "payments", want: AdmitPolicyNever, wantRule: 2},
+		{name: "path prefix", path: "/validate/services", namespace: "default", want: AdmitPolicyNever, wantRule: 3},
+		{name: "cluster-scoped", path: "/validate", namespace: "", want: AdmitPolicyNever, wantRule: 3},
+		{name: "default", path: "/mutate", namespace: "default", want: AdmitPolicyAlways, wantRule: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rule := ap.For(tt.path, tt.namespace)
+			if got != tt.want {
+				t.Errorf("For(%s, %s) = %s, want %s", tt.path, tt.namespace, got, tt.want)
+			}
+			if tt.wantRule < 0 {
+				if rule != nil {
+					t.Errorf("For(%s, %s) rule = %s, want the default policy", tt.path, tt.namespace, rule)
+				}
+				return
+			}
+			if rule != &ap.Rules[tt.wantRule] {
+				t.Errorf("For(%s, %s) rule = %v, want %s", tt.path, tt.namespace, rule, &ap.Rules[tt.wantRule])
+			}
+		})
+	}
+}
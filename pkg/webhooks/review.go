@@ -0,0 +1,115 @@
+package webhooks
+
+import (
+	admissionV1 "k8s.io/api/admission/v1"
+	admissionV1beta1 "k8s.io/api/admission/v1beta1"
+)
+
+// AdmissionReview is the version-neutral view of the AdmissionReview sent by
+// the API server. Both admission.k8s.io/v1 and admission.k8s.io/v1beta1
+// reviews are decoded into it, and the response is sent back using the same
+// APIVersion the request came with.
+type AdmissionReview struct {
+	APIVersion string // apiVersion of the AdmissionReview received
+	Request    *admissionV1.AdmissionRequest
+
+	// set by the server before calling the handler
+	Path    string // URL path of the request
+	Handler string // name of the handler being called
+}
+
+// V1beta1AdmissionHandler is the signature of the handlers written against
+// the admission.k8s.io/v1beta1 API.
+type V1beta1AdmissionHandler func(*admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse
+
+// V1beta1Handler adapts a v1beta1 handler to the version-neutral AdmissionHandler
+func V1beta1Handler(h V1beta1AdmissionHandler) AdmissionHandler {
+	return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+		resp := h(&admissionV1beta1.AdmissionReview{
+			Request: RequestToV1beta1(ar.Request),
+		})
+		return ResponseFromV1beta1(resp)
+	}
+}
+
+func RequestToV1beta1(in *admissionV1.AdmissionRequest) *admissionV1beta1.AdmissionRequest {
+	if in == nil {
+		return nil
+	}
+	return &admissionV1beta1.AdmissionRequest{
+		UID:                in.UID,
+		Kind:               in.Kind,
+		Resource:           in.Resource,
+		SubResource:        in.SubResource,
+		RequestKind:        in.RequestKind,
+		RequestResource:    in.RequestResource,
+		RequestSubResource: in.RequestSubResource,
+		Name:               in.Name,
+		Namespace:          in.Namespace,
+		Operation:          admissionV1beta1.Operation(in.Operation),
+		UserInfo:           in.UserInfo,
+		Object:             in.Object,
+		OldObject:          in.OldObject,
+		DryRun:             in.DryRun,
+		Options:            in.Options,
+	}
+}
+
+func RequestFromV1beta1(in *admissionV1beta1.AdmissionRequest) *admissionV1.AdmissionRequest {
+	if in == nil {
+		return nil
+	}
+	return &admissionV1.AdmissionRequest{
+		UID:                in.UID,
+		Kind:               in.Kind,
+		Resource:           in.Resource,
+		SubResource:        in.SubResource,
+		RequestKind:        in.RequestKind,
+		RequestResource:    in.RequestResource,
+		RequestSubResource: in.RequestSubResource,
+		Name:               in.Name,
+		Namespace:          in.Namespace,
+		Operation:          admissionV1.Operation(in.Operation),
+		UserInfo:           in.UserInfo,
+		Object:             in.Object,
+		OldObject:          in.OldObject,
+		DryRun:             in.DryRun,
+		Options:            in.Options,
+	}
+}
+
+func ResponseToV1beta1(in *admissionV1.AdmissionResponse) *admissionV1beta1.AdmissionResponse {
+	if in == nil {
+		return nil
+	}
+	out := &admissionV1beta1.AdmissionResponse{
+		UID:              in.UID,
+		Allowed:          in.Allowed,
+		Result:           in.Result,
+		Patch:            in.Patch,
+		AuditAnnotations: in.AuditAnnotations,
+	}
+	if in.PatchType != nil {
+		pt := admissionV1beta1.PatchType(*in.PatchType)
+		out.PatchType = &pt
+	}
+	return out
+}
+
+func ResponseFromV1beta1(in *admissionV1beta1.AdmissionResponse) *admissionV1.AdmissionResponse {
+	if in == nil {
+		return nil
+	}
+	out := &admissionV1.AdmissionResponse{
+		UID:              in.UID,
+		Allowed:          in.Allowed,
+		Result:           in.Result,
+		Patch:            in.Patch,
+		AuditAnnotations: in.AuditAnnotations,
+	}
+	if in.PatchType != nil {
+		pt := admissionV1.PatchType(*in.PatchType)
+		out.PatchType = &pt
+	}
+	return out
+}
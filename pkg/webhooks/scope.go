@@ -0,0 +1,82 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// names of the cluster-wide factories shared by the plugins, the scoped
+	// factories are named after them and their scope
+	KubernetesFactoryName string = "kubernetes"
+	DynamicFactoryName    string = "dynamic"
+)
+
+// FactoryScope is what the informers of a factory watch: the objects of a
+// namespace, all of them when empty, matching the label and field selectors.
+// The plugins ask for the narrowest scope they need, the ones asking for the
+// same scope share the factory.
+type FactoryScope struct {
+	Namespace     string
+	LabelSelector string
+	FieldSelector string
+	Resync        time.Duration
+}
+
+// Validate checks the selectors
+func (s FactoryScope) Validate() error {
+	if _, err := labels.Parse(s.LabelSelector); err != nil {
+		return errors.New(fmt.Sprintf("invalid label selector %q: %v", s.LabelSelector, err))
+	}
+	if _, err := fields.ParseSelector(s.FieldSelector); err != nil {
+		return errors.New(fmt.Sprintf("invalid field selector %q: %v", s.FieldSelector, err))
+	}
+	return nil
+}
+
+// FactoryName is the name of the factory of the scope, the base name alone
+// for the cluster-wide scope without resync
+func (s FactoryScope) FactoryName(base string) string {
+	var parts []string
+	if s.Namespace != "" {
+		parts = append(parts, "namespace="+s.Namespace)
+	}
+	if s.LabelSelector != "" {
+		parts = append(parts, "labels="+s.LabelSelector)
+	}
+	if s.FieldSelector != "" {
+		parts = append(parts, "fields="+s.FieldSelector)
+	}
+	if s.Resync != 0 {
+		parts = append(parts, "resync="+s.Resync.String())
+	}
+	if len(parts) == 0 {
+		return base
+	}
+	return base + ":" + strings.Join(parts, ",")
+}
+
+// TweakListOptions applies the selectors to the list and watch requests of
+// the informers
+func (s FactoryScope) TweakListOptions(options *metav1.ListOptions) {
+	if s.LabelSelector != "" {
+		options.LabelSelector = s.LabelSelector
+	}
+	if s.FieldSelector != "" {
+		options.FieldSelector = s.FieldSelector
+	}
+}
+
+// ConfigMapScope is the scope watching only the ConfigMap of the server
+func (c *WebhookServerConfig) ConfigMapScope() FactoryScope {
+	return FactoryScope{
+		Namespace:     c.CmNamespace,
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", c.CmName).String(),
+	}
+}
@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFactoryScopeFactoryName(t *testing.T) {
+	tests := []struct {
+		scope FactoryScope
+		want  string
+	}{
+		{scope: FactoryScope{}, want: "kubernetes"},
+		{scope: FactoryScope{Namespace: "kube-system"}, want: "kubernetes:namespace=kube-system"},
+		{
+			scope: FactoryScope{Namespace: "prod", LabelSelector: "app=web", FieldSelector: "metadata.name=web", Resync: time.Minute},
+			want:  "kubernetes:namespace=prod,labels=app=web,fields=metadata.name=web,resync=1m0s",
+		},
+		{scope: FactoryScope{Resync: 10 * time.Minute}, want: "kubernetes:resync=10m0s"},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.FactoryName(KubernetesFactoryName); got != tt.want {
+			t.Errorf("FactoryName() of %+v = %q, want %q", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestFactoryScopeValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		scope   FactoryScope
+		wantErr bool
+	}{
+		{name: "cluster-wide", scope: FactoryScope{}},
+		{name: "selectors", scope: FactoryScope{LabelSelector: "app in (web, api),!canary", FieldSelector: "metadata.name=web"}},
+		{name: "invalid label selector", scope: FactoryScope{LabelSelector: "app in (web"}, wantErr: true},
+		{name: "invalid field selector", scope: FactoryScope{FieldSelector: "metadata.name"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.scope.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFactoryScopeTweakListOptions(t *testing.T) {
+	options := metav1.ListOptions{LabelSelector: "kept", ResourceVersion: "1"}
+	FactoryScope{FieldSelector: "metadata.name=web"}.TweakListOptions(&options)
+	want := metav1.ListOptions{LabelSelector: "kept", FieldSelector: "metadata.name=web", ResourceVersion: "1"}
+	if options != want {
+		t.Errorf("TweakListOptions() = %+v, want %+v", options, want)
+	}
+}
+
+func TestConfigMapScope(t *testing.T) {
+	config := NewDefaultWebhookServerConfig()
+	config.CmNamespace = "webhooks"
+	config.CmName = "webhooks-config"
+	want := FactoryScope{Namespace: "webhooks", FieldSelector: "metadata.name=webhooks-config"}
+	if got := config.ConfigMapScope(); got != want {
+		t.Errorf("ConfigMapScope() = %+v, want %+v", got, want)
+	}
+}
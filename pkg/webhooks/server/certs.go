@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+const (
+	certReloadInterval   time.Duration = 10 * time.Second
+	certExpiryWarning    time.Duration = 7 * 24 * time.Hour
+	certExpiryLogEvery   time.Duration = time.Hour
+	certReloadRetryEvery time.Duration = time.Minute
+)
+
+// certWatcher keeps the x509 key pair loaded from CertFile/KeyFile and
+// serves it through tls.Config.GetCertificate. The files are polled for
+// changes and the new pair is swapped in atomically, a pair failing to
+// load is skipped and the last good one stays in use.
+type certWatcher struct {
+	certFile string
+	keyFile  string
+
+	cert atomic.Value // *tls.Certificate
+
+	mu            sync.Mutex
+	certModTime   time.Time // as of the last load attempt
+	keyModTime    time.Time
+	lastAttempt   time.Time
+	lastExpiryLog time.Time
+}
+
+func newCertWatcher(certFile, keyFile string) *certWatcher {
+	return &certWatcher{certFile: certFile, keyFile: keyFile}
+}
+
+func (cw *certWatcher) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, ok := cw.cert.Load().(*tls.Certificate); ok {
+		return cert, nil
+	}
+	return nil, errors.New("no TLS certificate loaded")
+}
+
+// Loaded reports whether a valid key pair is being served
+func (cw *certWatcher) Loaded() bool {
+	_, ok := cw.cert.Load().(*tls.Certificate)
+	return ok
+}
+
+// NotAfter returns the expiration of the certificate being served
+func (cw *certWatcher) NotAfter() time.Time {
+	if cert, ok := cw.cert.Load().(*tls.Certificate); ok && cert.Leaf != nil {
+		return cert.Leaf.NotAfter
+	}
+	return time.Time{}
+}
+
+// Load reads the key pair if the files changed since the last attempt, it's
+// safe to call it concurrently with GetCertificate
+func (cw *certWatcher) Load() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	certStat, err := os.Stat(cw.certFile)
+	if err != nil {
+		return err
+	}
+	keyStat, err := os.Stat(cw.keyFile)
+	if err != nil {
+		return err
+	}
+	unchanged := certStat.ModTime().Equal(cw.certModTime) && keyStat.ModTime().Equal(cw.keyModTime)
+	if unchanged && (cw.Loaded() || time.Since(cw.lastAttempt) < certReloadRetryEvery) {
+		// nothing new, or a broken pair we retry only once in a while
+		return nil
+	}
+	cw.lastAttempt = time.Now()
+	cw.certModTime = certStat.ModTime()
+	cw.keyModTime = keyStat.ModTime()
+
+	pair, err := tls.LoadX509KeyPair(cw.certFile, cw.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair: %v", err)
+	}
+	if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	if time.Now().After(pair.Leaf.NotAfter) {
+		return fmt.Errorf("certificate %s expired on %v", cw.certFile, pair.Leaf.NotAfter)
+	}
+
+	if old, ok := cw.cert.Load().(*tls.Certificate); !ok || !sameCertificate(old, &pair) {
+		cw.cert.Store(&pair)
+		cw.lastExpiryLog = time.Time{}
+		klog.Infof("Loaded TLS certificate %s (serial: %v, expires: %v)",
+			cw.certFile, pair.Leaf.SerialNumber, pair.Leaf.NotAfter)
+	}
+	return nil
+}
+
+func (cw *certWatcher) checkExpiry() {
+	notAfter := cw.NotAfter()
+	if notAfter.IsZero() || time.Until(notAfter) > certExpiryWarning {
+		return
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if time.Since(cw.lastExpiryLog) < certExpiryLogEvery {
+		return
+	}
+	cw.lastExpiryLog = time.Now()
+	klog.Warningf("TLS certificate %s expires in %v (on %v)",
+		cw.certFile, time.Until(notAfter).Round(time.Second), notAfter)
+}
+
+// Run polls the files until stopCh is closed
+func (cw *certWatcher) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+	for {
+		if err := cw.Load(); err != nil {
+			klog.Errorf("Can't reload TLS certificate, keep serving the last good one: %v", err)
+		}
+		cw.checkExpiry()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sameCertificate(a, b *tls.Certificate) bool {
+	if len(a.Certificate) != len(b.Certificate) {
+		return false
+	}
+	for i := range a.Certificate {
+		if string(a.Certificate[i]) != string(b.Certificate[i]) {
+			return false
+		}
+	}
+	return true
+}
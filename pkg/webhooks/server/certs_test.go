@@ -0,0 +1,184 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a self-signed key pair valid until notAfter, the files
+// get the given modification time so that every write is seen as a change
+func writeKeyPair(t *testing.T, certFile, keyFile string, serial int64, notAfter, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "webhooks"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), modTime)
+}
+
+func writeFile(t *testing.T, name string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedSerial(t *testing.T, cw *certWatcher) int64 {
+	t.Helper()
+	cert, err := cw.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func newTestCertWatcher(t *testing.T) (cw *certWatcher, certFile, keyFile string, cleanup func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	return newCertWatcher(certFile, keyFile), certFile, keyFile, func() { os.RemoveAll(dir) }
+}
+
+func TestCertWatcherReload(t *testing.T) {
+	cw, certFile, keyFile, cleanup := newTestCertWatcher(t)
+	defer cleanup()
+
+	if _, err := cw.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Error("GetCertificate() before any load should fail")
+	}
+	if err := cw.Load(); err == nil {
+		t.Error("Load() of missing files should fail")
+	}
+
+	notAfter := time.Now().Add(30 * 24 * time.Hour)
+	modTime := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFile, keyFile, 1, notAfter, modTime)
+	if err := cw.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := servedSerial(t, cw); got != 1 {
+		t.Errorf("serial = %d, want 1", got)
+	}
+	if !cw.Loaded() || !cw.NotAfter().Equal(notAfter.Truncate(time.Second)) {
+		t.Errorf("Loaded() = %v, NotAfter() = %v, want %v", cw.Loaded(), cw.NotAfter(), notAfter)
+	}
+
+	// the rewritten files are swapped in
+	writeKeyPair(t, certFile, keyFile, 2, notAfter, modTime.Add(time.Minute))
+	if err := cw.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := servedSerial(t, cw); got != 2 {
+		t.Errorf("serial = %d after the files changed, want 2", got)
+	}
+}
+
+func TestCertWatcherFailedReload(t *testing.T) {
+	cw, certFile, keyFile, cleanup := newTestCertWatcher(t)
+	defer cleanup()
+
+	modTime := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFile, keyFile, 1, time.Now().Add(30*24*time.Hour), modTime)
+	if err := cw.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		write func(modTime time.Time)
+	}{
+		{
+			name:  "broken certificate",
+			write: func(modTime time.Time) { writeFile(t, certFile, []byte("not a certificate"), modTime) },
+		},
+		{
+			name: "expired certificate",
+			write: func(modTime time.Time) {
+				writeKeyPair(t, certFile, keyFile, 3, time.Now().Add(-time.Hour), modTime)
+			},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.write(modTime.Add(time.Duration(i+1) * time.Minute))
+			if err := cw.Load(); err == nil {
+				t.Error("Load() should fail")
+			}
+			// the last good pair is still served
+			if got := servedSerial(t, cw); got != 1 {
+				t.Errorf("serial = %d, want the last good one", got)
+			}
+			// and the broken files are not retried on every poll
+			if err := cw.Load(); err != nil {
+				t.Errorf("Load() of the same broken files error = %v, want a skip", err)
+			}
+		})
+	}
+}
+
+func TestCertWatcherExpiryWarning(t *testing.T) {
+	cw, certFile, keyFile, cleanup := newTestCertWatcher(t)
+	defer cleanup()
+
+	modTime := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFile, keyFile, 1, time.Now().Add(30*24*time.Hour), modTime)
+	if err := cw.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cw.checkExpiry()
+	if !cw.lastExpiryLog.IsZero() {
+		t.Error("expiry warning logged for a certificate far from its expiration")
+	}
+
+	writeKeyPair(t, certFile, keyFile, 2, time.Now().Add(24*time.Hour), modTime.Add(time.Minute))
+	if err := cw.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cw.checkExpiry()
+	warned := cw.lastExpiryLog
+	if warned.IsZero() {
+		t.Fatal("no expiry warning for a certificate expiring in a day")
+	}
+	// the warning is rate limited
+	cw.checkExpiry()
+	if !cw.lastExpiryLog.Equal(warned) {
+		t.Error("expiry warning logged again before certExpiryLogEvery")
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"sync"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/trilogy-group/k8s-webhooks/pkg/utils"
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// clientProvider builds the clients shared by the server and the plugins on
+// first use, from the kubeconfig and the client settings of the config. A
+// failure isn't kept, the next call tries again.
+type clientProvider struct {
+	lock       sync.Mutex
+	config     *WebhookServerConfig
+	restConfig *rest.Config
+	kube       kubernetes.Interface
+	dynamic    dynamic.Interface
+}
+
+// getRestConfig loads the client config, with the lock held
+func (cp *clientProvider) getRestConfig() (*rest.Config, error) {
+	if cp.restConfig != nil {
+		return cp.restConfig, nil
+	}
+	cfg, err := utils.GetRestConfig(cp.config.Kubeconfig, cp.config.KubeOverrides)
+	if err != nil {
+		return nil, err
+	}
+	if cp.config.KubeAPIQPS > 0 {
+		cfg.QPS = cp.config.KubeAPIQPS
+	}
+	if cp.config.KubeAPIBurst > 0 {
+		cfg.Burst = cp.config.KubeAPIBurst
+	}
+	if cp.config.UserAgent != "" {
+		cfg.UserAgent = cp.config.UserAgent
+	}
+	cp.restConfig = cfg
+	return cfg, nil
+}
+
+func (cp *clientProvider) kubeClient() (kubernetes.Interface, error) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if cp.kube != nil {
+		return cp.kube, nil
+	}
+	cfg, err := cp.getRestConfig()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cp.kube = cs
+	return cs, nil
+}
+
+func (cp *clientProvider) dynamicClient() (dynamic.Interface, error) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	if cp.dynamic != nil {
+		return cp.dynamic, nil
+	}
+	cfg, err := cp.getRestConfig()
+	if err != nil {
+		return nil, err
+	}
+	dc, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cp.dynamic = dc
+	return dc, nil
+}
+
+// KubeClient returns the client shared by the server and the plugins, built
+// on first use
+func (whsrv *webhookServer) KubeClient() (kubernetes.Interface, error) {
+	return whsrv.clients.kubeClient()
+}
+
+// DynamicClient returns the dynamic client shared by the server and the
+// plugins, built on first use
+func (whsrv *webhookServer) DynamicClient() (dynamic.Interface, error) {
+	return whsrv.clients.dynamicClient()
+}
+
+// WithKubeClient makes the server and the plugins use the client, e.g. a fake
+// one in tests
+func WithKubeClient(client kubernetes.Interface) WebhookServerOption {
+	return func(whsrv WebhookServer) WebhookServer {
+		if ws, ok := whsrv.(*webhookServer); ok {
+			ws.clients.lock.Lock()
+			ws.clients.kube = client
+			ws.clients.lock.Unlock()
+		}
+		return whsrv
+	}
+}
+
+// WithDynamicClient makes the server and the plugins use the dynamic client,
+// e.g. a fake one in tests
+func WithDynamicClient(client dynamic.Interface) WebhookServerOption {
+	return func(whsrv WebhookServer) WebhookServer {
+		if ws, ok := whsrv.(*webhookServer); ok {
+			ws.clients.lock.Lock()
+			ws.clients.dynamic = client
+			ws.clients.lock.Unlock()
+		}
+		return whsrv
+	}
+}
+
+// WithRestConfig builds the clients from the config instead of loading the
+// kubeconfig, the client settings of WebhookServerConfig don't apply to it
+func WithRestConfig(cfg *rest.Config) WebhookServerOption {
+	return func(whsrv WebhookServer) WebhookServer {
+		if ws, ok := whsrv.(*webhookServer); ok {
+			ws.clients.lock.Lock()
+			ws.clients.restConfig = cfg
+			ws.clients.lock.Unlock()
+		}
+		return whsrv
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func TestWithKubeClient(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ws := NewWebhookServerWithOptions(nil, nil, WithKubeClient(client))
+
+	for i := 0; i < 2; i++ {
+		got, err := ws.KubeClient()
+		if err != nil {
+			t.Fatalf("KubeClient() error = %v", err)
+		}
+		if got != client {
+			t.Fatalf("KubeClient() = %v, want the client given as option", got)
+		}
+	}
+}
+
+func TestWithKubeClientConfigMap(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	config := NewDefaultWebhookServerConfig()
+	config.UseConfigMap = true
+	config.DefaultAdmitPolicy = string(AdmitPolicyNever)
+	ws := NewWebhookServerWithOptions(config, nil, WithKubeClient(client)).(*webhookServer)
+	defer close(ws.stopCh)
+
+	// the ConfigMap is set up with the client given as option
+	cm, err := client.CoreV1().ConfigMaps(config.CmNamespace).Get(config.CmName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("ConfigMap %s/%s not created: %v", config.CmNamespace, config.CmName, err)
+	}
+	if got := cm.Data[defaultAdmitPolicyKey]; got != config.DefaultAdmitPolicy {
+		t.Errorf("%s = %q, want %q", defaultAdmitPolicyKey, got, config.DefaultAdmitPolicy)
+	}
+
+	// and watched through the factory of its scope, built from the same client
+	f := ws.GetFactory(config.ConfigMapScope().FactoryName(KubernetesFactoryName))
+	if f == nil {
+		t.Fatal("no factory watching the ConfigMap")
+	}
+	ws.runFactories()
+	for _, ok := range f.WaitForCacheSync(ws.stopCh) {
+		if !ok {
+			t.Fatal("ConfigMap cache not synced")
+		}
+	}
+	cm = cm.DeepCopy()
+	cm.Data[defaultAdmitPolicyKey] = string(AdmitPolicyAlways)
+	if _, err := client.CoreV1().ConfigMaps(config.CmNamespace).Update(cm); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if policy, _ := ws.admitPolicy(&AdmissionReview{}); policy == AdmitPolicyAlways {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the update of the ConfigMap wasn't applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithKubeClientSelfSignedCerts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	config := NewDefaultWebhookServerConfig()
+	config.SelfSignedCerts = true
+	ws := NewWebhookServerWithOptions(config, nil, WithKubeClient(client)).(*webhookServer)
+
+	// the certificates are set up after the options, with the given client
+	secret, err := client.CoreV1().Secrets(config.CertsSecretNamespace).Get(config.CertsSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Secret %s/%s not created: %v", config.CertsSecretNamespace, config.CertsSecretName, err)
+	}
+	if len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[secretCAKey]) == 0 {
+		t.Errorf("Secret %s/%s without certificate or CA", secret.Namespace, secret.Name)
+	}
+	if !ws.certs.Loaded() {
+		t.Error("self-signed certificate not loaded")
+	}
+}
+
+func TestWithDynamicClient(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	ws := NewWebhookServerWithOptions(nil, nil, WithDynamicClient(client))
+
+	got, err := ws.DynamicClient()
+	if err != nil {
+		t.Fatalf("DynamicClient() error = %v", err)
+	}
+	if got != client {
+		t.Errorf("DynamicClient() = %v, want the client given as option", got)
+	}
+}
+
+func TestWithRestConfig(t *testing.T) {
+	config := NewDefaultWebhookServerConfig()
+	config.UserAgent = "ignored"
+	cfg := &rest.Config{Host: "https://example.com:6443", UserAgent: "test"}
+	ws := NewWebhookServerWithOptions(config, nil, WithRestConfig(cfg)).(*webhookServer)
+
+	kube, err := ws.KubeClient()
+	if err != nil {
+		t.Fatalf("KubeClient() error = %v", err)
+	}
+	if again, _ := ws.KubeClient(); again != kube {
+		t.Error("KubeClient() built another client")
+	}
+	if _, err := ws.DynamicClient(); err != nil {
+		t.Fatalf("DynamicClient() error = %v", err)
+	}
+	if ws.clients.restConfig != cfg || cfg.UserAgent != "test" {
+		t.Errorf("rest config = %+v, want the one given as option, unchanged", ws.clients.restConfig)
+	}
+}
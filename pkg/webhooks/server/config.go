@@ -1,23 +1,29 @@
 package server
 
 import (
-	"time"
-
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
 
-	"github.com/trilogy-group/k8s-webhooks/pkg/utils"
+const (
+	defaultAdmitPolicyKey string = "DefaultAdmitPolicy"
+	admitPoliciesKey      string = "AdmitPolicies"
 )
 
-// for now manage only default admit policy
+// for now manage only the admit policies
 func (ws *webhookServer) setupConfigMap() {
 	config := ws.GetConfig()
-	cfg := utils.GetClientConfigOrDie(config.Kubeconfig)
-	cs := utils.GetClientsetFromConfigOrDie(cfg)
+	cs, err := ws.KubeClient()
+	if err != nil {
+		klog.Errorf("Can't get a client to watch the ConfigMap %s/%s: %v", config.CmNamespace, config.CmName, err)
+		return
+	}
 	// get initial values from CM
 	cm, err := cs.CoreV1().ConfigMaps(config.CmNamespace).
 		Get(config.CmName, metav1.GetOptions{})
@@ -29,23 +35,26 @@ func (ws *webhookServer) setupConfigMap() {
 				Name:      config.CmName,
 			},
 			Data: map[string]string{
-				"DefaultAdmitPolicy": config.DefaultAdmitPolicy,
+				defaultAdmitPolicyKey: config.DefaultAdmitPolicy,
 			},
 		}
 		cs.CoreV1().ConfigMaps(config.CmNamespace).Create(cm)
 	}
-	if policy, found := cm.Data["DefaultAdmitPolicy"]; found {
-		config.DefaultAdmitPolicy = policy
+	if _, found := cm.Data[defaultAdmitPolicyKey]; found {
+		ws.applyAdmitPolicies(cm)
 	} else {
 		// we should update the ConfigMap for the default admit policy
 		if cm.Data == nil {
 			cm.Data = make(map[string]string)
 		}
-		cm.Data["DefaultAdmitPolicy"] = config.DefaultAdmitPolicy
+		cm.Data[defaultAdmitPolicyKey] = config.DefaultAdmitPolicy
 		cs.CoreV1().ConfigMaps(config.CmNamespace).Update(cm)
 	}
-	f := informers.NewSharedInformerFactory(cs, 10*time.Minute)
-	ws.RegisterFactory("kubernetes", f)
+	f, err := ws.ScopedFactory(config.ConfigMapScope())
+	if err != nil {
+		klog.Errorf("Can't watch the ConfigMap %s/%s: %v", config.CmNamespace, config.CmName, err)
+		return
+	}
 	f.Core().V1().ConfigMaps().Informer().AddEventHandler(
 		cache.FilteringResourceEventHandler{
 			FilterFunc: func(obj interface{}) bool {
@@ -60,13 +69,44 @@ func (ws *webhookServer) setupConfigMap() {
 			Handler: cache.ResourceEventHandlerFuncs{
 				UpdateFunc: func(old interface{}, new interface{}) {
 					cm := new.(*corev1.ConfigMap)
-					if policy, found := cm.Data["DefaultAdmitPolicy"]; found {
-						config.DefaultAdmitPolicy = policy
+					if _, found := cm.Data[defaultAdmitPolicyKey]; found {
+						ws.applyAdmitPolicies(cm)
 					} else {
-						cm.Data["DefaultAdmitPolicy"] = config.DefaultAdmitPolicy
+						cm = cm.DeepCopy()
+						if cm.Data == nil {
+							cm.Data = make(map[string]string)
+						}
+						cm.Data[defaultAdmitPolicyKey] = config.DefaultAdmitPolicy
 						cs.CoreV1().ConfigMaps(config.CmNamespace).Update(cm)
 					}
 				},
 			},
 		})
 }
+
+// applyAdmitPolicies applies the admit policies of the ConfigMap, an invalid
+// value (e.g. "never") is logged and the current policies are kept. Without
+// the AdmitPolicies key there are no rules, only the default policy.
+// The config keeps the policies given at startup, the ones in effect are only
+// in admitPolicies, so requests in flight never see them half updated.
+func (ws *webhookServer) applyAdmitPolicies(cm *corev1.ConfigMap) {
+	config := ws.GetConfig()
+	policy := config.DefaultAdmitPolicy
+	if p, found := cm.Data[defaultAdmitPolicyKey]; found {
+		policy = p
+	}
+	var rules []AdmitPolicyRule
+	if data, found := cm.Data[admitPoliciesKey]; found {
+		parsed, err := ParseAdmitPolicyRules(data)
+		if err != nil {
+			klog.Errorf("Invalid %s in ConfigMap %s/%s, keeping the current admit policies: %v",
+				admitPoliciesKey, cm.Namespace, cm.Name, err)
+			return
+		}
+		rules = parsed
+	}
+	if err := ws.setAdmitPolicies(policy, rules); err != nil {
+		klog.Errorf("Invalid admit policies in ConfigMap %s/%s, keeping the current ones: %v",
+			cm.Namespace, cm.Name, err)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func TestApplyAdmitPolicies(t *testing.T) {
+	current := []AdmitPolicyRule{{Path: "/validate", Policy: AdmitPolicyNever}}
+	tests := []struct {
+		name        string
+		data        map[string]string
+		wantDefault AdmitPolicy
+		wantRules   []AdmitPolicyRule
+	}{
+		{
+			name:        "rules replaced",
+			data:        map[string]string{"DefaultAdmitPolicy": "Never", "AdmitPolicies": "- namespace: payments\n  policy: Always\n"},
+			wantDefault: AdmitPolicyNever,
+			wantRules:   []AdmitPolicyRule{{Namespace: "payments", Policy: AdmitPolicyAlways}},
+		},
+		{
+			name:        "rules removed with the key",
+			data:        map[string]string{"DefaultAdmitPolicy": "Always"},
+			wantDefault: AdmitPolicyAlways,
+		},
+		{
+			name:        "invalid rules keep the current policies",
+			data:        map[string]string{"DefaultAdmitPolicy": "Never", "AdmitPolicies": "- path: /x\n  policy: never\n"},
+			wantDefault: AdmitPolicyAlways,
+			wantRules:   current,
+		},
+		{
+			name:        "invalid default keeps the current policies",
+			data:        map[string]string{"DefaultAdmitPolicy": "never"},
+			wantDefault: AdmitPolicyAlways,
+			wantRules:   current,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewDefaultWebhookServerConfig()
+			config.UseConfigMap = false
+			config.DefaultAdmitPolicy = string(AdmitPolicyAlways)
+			config.AdmitPolicies = current
+			ws := NewWebhookServer(config, nil).(*webhookServer)
+
+			ws.applyAdmitPolicies(&corev1.ConfigMap{Data: tt.data})
+			ap := ws.admitPolicies.Load().(*AdmitPolicies)
+			if ap.Default != tt.wantDefault {
+				t.Errorf("default admit policy = %s, want %s", ap.Default, tt.wantDefault)
+			}
+			if !reflect.DeepEqual(ap.Rules, tt.wantRules) {
+				t.Errorf("admit policy rules = %+v, want %+v", ap.Rules, tt.wantRules)
+			}
+			// the config isn't shared with the requests, it keeps the startup policies
+			if config.DefaultAdmitPolicy != string(AdmitPolicyAlways) || !reflect.DeepEqual(config.AdmitPolicies, current) {
+				t.Errorf("config changed to %s %+v", config.DefaultAdmitPolicy, config.AdmitPolicies)
+			}
+		})
+	}
+}
+
+func TestInvalidAdmitPoliciesFailClosed(t *testing.T) {
+	config := NewDefaultWebhookServerConfig()
+	config.UseConfigMap = false
+	config.DefaultAdmitPolicy = "always"
+	ws := NewWebhookServer(config, nil).(*webhookServer)
+	if policy, _ := ws.admitPolicy(&AdmissionReview{Path: "/validate"}); policy != AdmitPolicyNever {
+		t.Errorf("admit policy = %s, want %s", policy, AdmitPolicyNever)
+	}
+}
+
+// countedConfig counts its validations, one per update of its section
+type countedConfig struct {
+	Value string `yaml:"value"`
+}
+
+var countedValidations int32
+
+func (c *countedConfig) Validate() error {
+	atomic.AddInt32(&countedValidations, 1)
+	return nil
+}
+
+func TestWatchConfigSection(t *testing.T) {
+	whsrv := newTestServer(t)
+	defer close(whsrv.stopCh)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: whsrv.config.CmNamespace, Name: whsrv.config.CmName},
+		Data:       map[string]string{"counted": "value: a\n"},
+	}
+	client := fake.NewSimpleClientset(cm)
+	whsrv.clients.kube = client
+	section := NewConfigSection("counted", func() PluginConfig { return &countedConfig{} })
+	value := func() string { return section.Current().(*countedConfig).Value }
+
+	// set up twice, e.g. a plugin disabled and enabled again
+	for i := 0; i < 2; i++ {
+		if err := WatchConfigSection(whsrv, section); err != nil {
+			t.Fatalf("WatchConfigSection() error = %v", err)
+		}
+	}
+	if value() != "a" {
+		t.Fatalf("value = %q, want the one of the ConfigMap", value())
+	}
+
+	whsrv.runFactories()
+	factory := whsrv.config.ConfigMapScope().FactoryName(KubernetesFactoryName)
+	if err := waitFor(func() bool { return whsrv.informersSynced(factory)["*v1.ConfigMap"] }); err != nil {
+		t.Fatal("ConfigMap informer not synced")
+	}
+	atomic.StoreInt32(&countedValidations, 0)
+	cm = cm.DeepCopy()
+	cm.Data["counted"] = "value: b\n"
+	if _, err := client.CoreV1().ConfigMaps(cm.Namespace).Update(cm); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(func() bool { return value() == "b" }); err != nil {
+		t.Fatal("the update of the ConfigMap wasn't applied")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&countedValidations); n != 1 {
+		t.Errorf("the update was applied %d times, want once", n)
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// RegisterDynamicFactory adds the dynamic factory under the name, unless
+// there's already one, typed or dynamic. Once the server is started,
+// StartInformers starts it.
+func (whsrv *webhookServer) RegisterDynamicFactory(name string, factory dynamicinformer.DynamicSharedInformerFactory) {
+	whsrv.factoriesLock.Lock()
+	defer whsrv.factoriesLock.Unlock()
+	if whsrv.dynamicFactories == nil {
+		whsrv.dynamicFactories = make(DynamicFactoriesMap)
+	}
+	if whsrv.factoryExists(name) {
+		return
+	}
+	whsrv.dynamicFactories[name] = factory
+}
+
+// GetOrCreateDynamicFactory returns the dynamic factory registered under the
+// name, creating and registering it when there's none. It returns nil when
+// the name is taken by a typed factory.
+func (whsrv *webhookServer) GetOrCreateDynamicFactory(name string, newFactory func() dynamicinformer.DynamicSharedInformerFactory) dynamicinformer.DynamicSharedInformerFactory {
+	// with the lock held
+	get := func() (dynamicinformer.DynamicSharedInformerFactory, bool) {
+		if f, ok := whsrv.dynamicFactories[name]; ok {
+			return f, true
+		}
+		if _, typed := whsrv.factories[name]; typed {
+			klog.Errorf("Factory %s is a typed factory", name)
+			return nil, true
+		}
+		return nil, false
+	}
+	whsrv.factoriesLock.RLock()
+	f, found := get()
+	whsrv.factoriesLock.RUnlock()
+	if found {
+		return f
+	}
+
+	// built without the lock, it's dropped if another caller was faster
+	created := newFactory()
+	whsrv.factoriesLock.Lock()
+	defer whsrv.factoriesLock.Unlock()
+	if f, found := get(); found {
+		return f
+	}
+	if whsrv.dynamicFactories == nil {
+		whsrv.dynamicFactories = make(DynamicFactoriesMap)
+	}
+	whsrv.dynamicFactories[name] = created
+	return created
+}
+
+func (whsrv *webhookServer) GetDynamicFactory(name string) dynamicinformer.DynamicSharedInformerFactory {
+	whsrv.factoriesLock.RLock()
+	defer whsrv.factoriesLock.RUnlock()
+	if f, ok := whsrv.dynamicFactories[name]; ok {
+		return f
+	}
+	return nil
+}
+
+// DynamicInformer returns the informer of the resource (e.g. a custom
+// resource) from the shared cluster-wide dynamic factory. Once the server is
+// started, it's started right away and /readyz waits for it.
+func (whsrv *webhookServer) DynamicInformer(gvr schema.GroupVersionResource) (informers.GenericInformer, error) {
+	f, err := whsrv.ScopedDynamicFactory(FactoryScope{})
+	if err != nil {
+		return nil, err
+	}
+	informer := f.ForResource(gvr)
+	informer.Informer()
+	whsrv.StartInformers()
+	return informer, nil
+}
+
+func dynamicFactorySynced(f dynamicinformer.DynamicSharedInformerFactory) func(stopCh <-chan struct{}) map[string]bool {
+	return func(stopCh <-chan struct{}) map[string]bool {
+		synced := make(map[string]bool)
+		for gvr, ok := range f.WaitForCacheSync(stopCh) {
+			synced[gvr.String()] = ok
+		}
+		return synced
+	}
+}
+
+func WithDynamicFactories(factoriesMap DynamicFactoriesMap) WebhookServerOption {
+	return func(whsrv WebhookServer) WebhookServer {
+		for name, factory := range factoriesMap {
+			whsrv.RegisterDynamicFactory(name, factory)
+		}
+		return whsrv
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func newTenant(name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Tenant",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+	}}
+}
+
+func TestFactoryNames(t *testing.T) {
+	whsrv := newTestServer(t)
+	typed := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	dynamic := dynamicinformer.NewDynamicSharedInformerFactory(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), 0)
+	whsrv.RegisterFactory("typed", typed)
+	whsrv.RegisterDynamicFactory("dynamic", dynamic)
+
+	// a name is taken by one factory only
+	whsrv.RegisterDynamicFactory("typed", dynamic)
+	whsrv.RegisterFactory("dynamic", typed)
+	if whsrv.GetDynamicFactory("typed") != nil || whsrv.GetFactory("dynamic") != nil {
+		t.Error("a factory was registered under a name already taken")
+	}
+	if f := whsrv.GetOrCreateDynamicFactory("typed", func() dynamicinformer.DynamicSharedInformerFactory { return dynamic }); f != nil {
+		t.Error("GetOrCreateDynamicFactory() of a typed factory name should return nil")
+	}
+	if f := whsrv.GetOrCreateFactory("dynamic", func() informers.SharedInformerFactory { return typed }); f != nil {
+		t.Error("GetOrCreateFactory() of a dynamic factory name should return nil")
+	}
+	if f := whsrv.GetOrCreateDynamicFactory("dynamic", nil); f != dynamic {
+		t.Error("GetOrCreateDynamicFactory() of a registered factory should return it")
+	}
+	if names := whsrv.factoryNames(); len(names) != 2 {
+		t.Errorf("factoryNames() = %v, want typed and dynamic", names)
+	}
+}
+
+func TestDynamicInformer(t *testing.T) {
+	whsrv := newTestServer(t)
+	defer close(whsrv.stopCh)
+	whsrv.clients.dynamic = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newTenant("acme"))
+	tenants := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "tenants"}
+
+	informer, err := whsrv.DynamicInformer(tenants)
+	if err != nil {
+		t.Fatalf("DynamicInformer() error = %v", err)
+	}
+	if whsrv.factoryStarted(DynamicFactoryName) {
+		t.Fatal("dynamic factory started before the server")
+	}
+
+	whsrv.runFactories()
+	if err := waitFor(func() bool { return whsrv.informersSynced(DynamicFactoryName)[tenants.String()] }); err != nil {
+		t.Fatalf("tenants informer not synced: %v", whsrv.informersSynced(DynamicFactoryName))
+	}
+	if _, err := informer.Lister().ByNamespace("default").Get("acme"); err != nil {
+		t.Errorf("tenant acme not in the cache: %v", err)
+	}
+
+	// once the server is started, an informer is started before being returned
+	projects := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "projects"}
+	if _, err := whsrv.DynamicInformer(projects); err != nil {
+		t.Fatalf("DynamicInformer() error = %v", err)
+	}
+	if _, started := whsrv.informersSynced(DynamicFactoryName)[projects.String()]; !started {
+		t.Errorf("projects informer not started: %v", whsrv.informersSynced(DynamicFactoryName))
+	}
+}
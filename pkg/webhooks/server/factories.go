@@ -3,19 +3,39 @@ package server
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"k8s.io/client-go/informers"
+	"k8s.io/klog"
 
 	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
 )
 
+// how often the informers added to the running factories are started
+const informersStartInterval time.Duration = time.Second
+
+// StartFactory starts the typed or dynamic factory and waits for the caches
+// of its informers to sync
 func (whsrv *webhookServer) StartFactory(factoryName string) error {
-	f, ok := whsrv.factories[factoryName]
-	if !ok {
+	whsrv.factoriesLock.Lock()
+	var waitForCacheSync func(stopCh <-chan struct{}) map[string]bool
+	if f, ok := whsrv.factories[factoryName]; ok {
+		f.Start(whsrv.stopCh)
+		waitForCacheSync = typedFactorySynced(f)
+	} else if f, ok := whsrv.dynamicFactories[factoryName]; ok {
+		f.Start(whsrv.stopCh)
+		waitForCacheSync = dynamicFactorySynced(f)
+	} else {
+		whsrv.factoriesLock.Unlock()
 		return errors.New(fmt.Sprintf("Unknown factory for name: %s", factoryName))
 	}
-	f.Start(whsrv.stopCh)
-	for _, ok = range f.WaitForCacheSync(whsrv.stopCh) {
+	if whsrv.startedFactories == nil {
+		whsrv.startedFactories = make(map[string]bool)
+	}
+	whsrv.startedFactories[factoryName] = true
+	whsrv.factoriesLock.Unlock()
+
+	for _, ok := range waitForCacheSync(whsrv.stopCh) {
 		if !ok {
 			return errors.New(fmt.Sprintf("failed to wait for caches to sync (factory name: %s)", factoryName))
 		}
@@ -23,16 +43,151 @@ func (whsrv *webhookServer) StartFactory(factoryName string) error {
 	return nil
 }
 
+// StartInformers starts the informers of the factories not started yet: the
+// ones added to running factories and the ones of the factories registered
+// late. It doesn't wait for the caches to sync, /readyz tells when they are.
+// It does nothing until the server is started.
+func (whsrv *webhookServer) StartInformers() {
+	whsrv.factoriesLock.Lock()
+	defer whsrv.factoriesLock.Unlock()
+	if !whsrv.factoriesRunning {
+		return
+	}
+	if whsrv.startedFactories == nil {
+		whsrv.startedFactories = make(map[string]bool)
+	}
+	// starting a factory again starts only its new informers
+	for name, f := range whsrv.factories {
+		f.Start(whsrv.stopCh)
+		whsrv.startedFactories[name] = true
+	}
+	for name, f := range whsrv.dynamicFactories {
+		f.Start(whsrv.stopCh)
+		whsrv.startedFactories[name] = true
+	}
+}
+
+// runFactories starts the registered factories, then keeps starting the
+// informers added from now on (e.g. by a plugin getting an informer from a
+// shared factory) until the server stops
+func (whsrv *webhookServer) runFactories() {
+	whsrv.factoriesLock.Lock()
+	whsrv.factoriesRunning = true
+	whsrv.factoriesLock.Unlock()
+	whsrv.StartInformers()
+	go func() {
+		ticker := time.NewTicker(informersStartInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-whsrv.stopCh:
+				return
+			case <-ticker.C:
+				whsrv.StartInformers()
+			}
+		}
+	}()
+}
+
+func (whsrv *webhookServer) factoryNames() []string {
+	whsrv.factoriesLock.RLock()
+	defer whsrv.factoriesLock.RUnlock()
+	names := make([]string, 0, len(whsrv.factories)+len(whsrv.dynamicFactories))
+	for fn := range whsrv.factories {
+		names = append(names, fn)
+	}
+	for fn := range whsrv.dynamicFactories {
+		names = append(names, fn)
+	}
+	return names
+}
+
+func (whsrv *webhookServer) factoryStarted(factoryName string) bool {
+	whsrv.factoriesLock.RLock()
+	defer whsrv.factoriesLock.RUnlock()
+	return whsrv.startedFactories[factoryName]
+}
+
+// informersSynced tells, without blocking, if the caches of the started
+// informers of the typed or dynamic factory are synced, by informer type or
+// resource
+func (whsrv *webhookServer) informersSynced(factoryName string) map[string]bool {
+	var waitForCacheSync func(stopCh <-chan struct{}) map[string]bool
+	if f := whsrv.GetFactory(factoryName); f != nil {
+		waitForCacheSync = typedFactorySynced(f)
+	} else if f := whsrv.GetDynamicFactory(factoryName); f != nil {
+		waitForCacheSync = dynamicFactorySynced(f)
+	} else {
+		return nil
+	}
+	// with a closed channel WaitForCacheSync just checks the current status
+	closedCh := make(chan struct{})
+	close(closedCh)
+	return waitForCacheSync(closedCh)
+}
+
+func typedFactorySynced(f informers.SharedInformerFactory) func(stopCh <-chan struct{}) map[string]bool {
+	return func(stopCh <-chan struct{}) map[string]bool {
+		synced := make(map[string]bool)
+		for informerType, ok := range f.WaitForCacheSync(stopCh) {
+			synced[informerType.String()] = ok
+		}
+		return synced
+	}
+}
+
+// RegisterFactory adds the factory under the name, unless there's already
+// one, typed or dynamic. Once the server is started, StartInformers starts it.
 func (whsrv *webhookServer) RegisterFactory(name string, factory informers.SharedInformerFactory) {
+	whsrv.factoriesLock.Lock()
+	defer whsrv.factoriesLock.Unlock()
 	if whsrv.factories == nil {
 		whsrv.factories = make(FactoriesMap)
 	}
-	if _, alreadyExists := whsrv.factories[name]; !alreadyExists {
-		whsrv.factories[name] = factory
+	if whsrv.factoryExists(name) {
+		return
+	}
+	whsrv.factories[name] = factory
+}
+
+// factoryExists tells if the name is taken by a typed or a dynamic factory,
+// with the lock held
+func (whsrv *webhookServer) factoryExists(name string) bool {
+	_, typed := whsrv.factories[name]
+	_, dynamic := whsrv.dynamicFactories[name]
+	return typed || dynamic
+}
+
+// GetOrCreateFactory returns the factory registered under the name, creating
+// and registering it when there's none, so the plugins sharing a factory don't
+// have to care about which one comes first. It returns nil when the name is
+// taken by a dynamic factory.
+func (whsrv *webhookServer) GetOrCreateFactory(name string, newFactory func() informers.SharedInformerFactory) informers.SharedInformerFactory {
+	if f := whsrv.GetFactory(name); f != nil {
+		return f
+	}
+
+	// built without the lock, it's dropped if another caller was faster
+	created := newFactory()
+	whsrv.factoriesLock.Lock()
+	defer whsrv.factoriesLock.Unlock()
+	if f, ok := whsrv.factories[name]; ok {
+		return f
+	}
+	if _, dynamic := whsrv.dynamicFactories[name]; dynamic {
+		klog.Errorf("Factory %s is a dynamic factory", name)
+		return nil
+	}
+	if whsrv.factories == nil {
+		whsrv.factories = make(FactoriesMap)
 	}
+	whsrv.factories[name] = created
+	return created
 }
 
 func (whsrv *webhookServer) GetFactory(name string) informers.SharedInformerFactory {
+	whsrv.factoriesLock.RLock()
+	defer whsrv.factoriesLock.RUnlock()
 	if f, ok := whsrv.factories[name]; ok {
 		return f
 	}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// waitFor polls the condition for a few seconds
+func waitFor(condition func() bool) error {
+	return wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return condition(), nil
+	})
+}
+
+func TestLateInformers(t *testing.T) {
+	whsrv := newTestServer(t)
+	defer close(whsrv.stopCh)
+	whsrv.RegisterFactory("kubernetes", informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0))
+	whsrv.GetFactory("kubernetes").Core().V1().ConfigMaps().Informer()
+
+	// nothing is started before the server
+	whsrv.StartInformers()
+	if whsrv.factoryStarted("kubernetes") {
+		t.Fatal("factory started before the server")
+	}
+
+	whsrv.runFactories()
+	if err := waitFor(func() bool { return whsrv.informersSynced("kubernetes")["*v1.ConfigMap"] }); err != nil {
+		t.Fatalf("ConfigMaps informer not synced: %v", whsrv.informersSynced("kubernetes"))
+	}
+
+	// an informer added to the running factory is started without StartInformers
+	whsrv.GetFactory("kubernetes").Apps().V1().Deployments().Informer()
+	if err := waitFor(func() bool { return whsrv.informersSynced("kubernetes")["*v1.Deployment"] }); err != nil {
+		t.Errorf("late Deployments informer not synced: %v", whsrv.informersSynced("kubernetes"))
+	}
+
+	// a factory registered late is started too
+	whsrv.RegisterFactory("late", informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0))
+	whsrv.GetFactory("late").Core().V1().Secrets().Informer()
+	whsrv.StartInformers()
+	if !whsrv.factoryStarted("late") {
+		t.Error("factory registered late not started by StartInformers")
+	}
+}
+
+func TestGetOrCreateFactory(t *testing.T) {
+	whsrv := newTestServer(t)
+	var lock sync.Mutex
+	created := 0
+	newFactory := func() informers.SharedInformerFactory {
+		lock.Lock()
+		created++
+		lock.Unlock()
+		// the server can be used while the factory is built
+		whsrv.GetFactory("other")
+		return informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	}
+
+	var wg sync.WaitGroup
+	factories := make([]informers.SharedInformerFactory, 10)
+	for i := range factories {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			factories[i] = whsrv.GetOrCreateFactory("shared", newFactory)
+		}(i)
+	}
+	wg.Wait()
+	for _, f := range factories {
+		if f != whsrv.GetFactory("shared") {
+			t.Fatal("GetOrCreateFactory() returned a factory other than the registered one")
+		}
+	}
+	if created == 0 {
+		t.Error("newFactory never called")
+	}
+
+	created = 0
+	if whsrv.GetOrCreateFactory("shared", newFactory) != factories[0] || created != 0 {
+		t.Errorf("GetOrCreateFactory() of a registered factory built %d factories", created)
+	}
+}
@@ -1,46 +1,83 @@
 package server
 
 import (
-	"errors"
-	"fmt"
-	"sort"
-	"strings"
+	admissionV1 "k8s.io/api/admission/v1"
 
 	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
 )
 
-func (whsrv *webhookServer) RegisterHandler(path string, h AdmissionHandler) error {
-	if whsrv.handlers == nil {
-		whsrv.handlers = make(HandlersMap)
-	}
-	if _, alreadyExists := whsrv.handlers[path]; alreadyExists {
-		return errors.New(fmt.Sprintf("Handler for path: %s already exists", path))
-	}
-	whsrv.handlers[path] = h
-	return nil
+const defaultHandlerName string = "default-admit-policy"
+
+type registeredHandler struct {
+	path    string
+	handler AdmissionHandler
+	config  *HandlerConfig
 }
 
+// RegisterHandler appends the handler to the ones of the path, they're called
+// in registration order
+func (whsrv *webhookServer) RegisterHandler(path string, h AdmissionHandler, opts ...HandlerOption) error {
+	return whsrv.handlers.register(path, h, opts...)
+}
+
+// UnregisterHandler removes the handler with the given name from the ones of
+// the path
+func (whsrv *webhookServer) UnregisterHandler(path, name string) error {
+	return whsrv.handlers.unregister(path, name)
+}
+
+// ReplaceHandler swaps the handler of the path having the same name (given
+// with WithHandlerName), keeping its place among the handlers of the path
+func (whsrv *webhookServer) ReplaceHandler(path string, h AdmissionHandler, opts ...HandlerOption) error {
+	return whsrv.handlers.replace(path, h, opts...)
+}
+
+// GetHandlerForPath returns the handlers of the path combined as one
 func (whsrv *webhookServer) GetHandlerForPath(path string) AdmissionHandler {
-	// try exact path match (faster)
-	if h, ok := whsrv.handlers[path]; ok {
-		return h
+	rhs := whsrv.lookupHandlers(path)
+	return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+		return whsrv.runHandlers(whsrv.selectHandlers(rhs, ar), ar)
 	}
-	// try with prefix match, longer is better
-	paths := make([]string, 0, len(whsrv.handlers))
-	for hPath, _ := range whsrv.handlers {
-		paths = append(paths, hPath)
+}
+
+// lookupHandlers returns the handlers registered for the path, or the one
+// implementing the default admit policy
+func (whsrv *webhookServer) lookupHandlers(path string) []*registeredHandler {
+	if rhs, found := whsrv.handlers.lookup(path); found {
+		return rhs
 	}
-	sort.Slice(paths, func(i, j int) bool { return len(paths[i]) > len(paths[j]) })
-	for _, hPath := range paths {
-		if strings.HasPrefix(path, hPath) {
-			return whsrv.handlers[hPath]
-		}
+	return []*registeredHandler{whsrv.defaultHandler("")}
+}
+
+// defaultHandler implements the default admit policy, for the requests no
+// handler is registered or matching for
+func (whsrv *webhookServer) defaultHandler(path string) *registeredHandler {
+	return &registeredHandler{
+		path:    path,
+		handler: whsrv.admitByPolicy,
+		config:  &HandlerConfig{Name: defaultHandlerName},
 	}
+}
+
+// Use adds middlewares wrapping all the handlers, outside the ones given at
+// registration time
+func (whsrv *webhookServer) Use(mws ...Middleware) {
+	whsrv.handlers.use(mws...)
+}
+
+// middlewares returns the chain for the handler, server-wide ones first
+func (whsrv *webhookServer) middlewares(rh *registeredHandler) []Middleware {
+	global := whsrv.handlers.globalMiddlewares()
+	mws := make([]Middleware, 0, len(global)+len(rh.config.Middlewares))
+	mws = append(mws, global...)
+	return append(mws, rh.config.Middlewares...)
+}
 
-	if whsrv.config.DefaultAdmitPolicy == "Never" {
-		return AdmitNever
+func WithMiddleware(mws ...Middleware) WebhookServerOption {
+	return func(whsrv WebhookServer) WebhookServer {
+		whsrv.Use(mws...)
+		return whsrv
 	}
-	return AdmitAlways
 }
 
 func WithHandlers(handlersMap HandlersMap) WebhookServerOption {
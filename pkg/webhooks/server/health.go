@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+const (
+	healthzPath string = "/healthz"
+	readyzPath  string = "/readyz"
+)
+
+// healthz is the liveness probe, it only tells the server is serving
+func (whsrv *webhookServer) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// readyz is the readiness probe, the server is ready once the TLS key pair is
+// loaded and all the registered factories are started with the caches of all
+// their informers synced
+func (whsrv *webhookServer) readyz(w http.ResponseWriter, r *http.Request) {
+	if reasons := whsrv.notReadyReasons(); len(reasons) > 0 {
+		klog.V(4).Infof("Not ready: %v", reasons)
+		http.Error(w, strings.Join(reasons, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func (whsrv *webhookServer) notReadyReasons() (reasons []string) {
+	if !whsrv.certs.Loaded() {
+		reasons = append(reasons, "TLS certificate not loaded")
+	}
+	for _, name := range whsrv.factoryNames() {
+		if !whsrv.factoryStarted(name) {
+			reasons = append(reasons, fmt.Sprintf("factory %s not started", name))
+			continue
+		}
+		for informerType, synced := range whsrv.informersSynced(name) {
+			if !synced {
+				reasons = append(reasons, fmt.Sprintf("factory %s informer %v caches not synced", name, informerType))
+			}
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
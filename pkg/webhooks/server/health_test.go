@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func probe(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestHealthz(t *testing.T) {
+	whsrv := newTestServer(t)
+	if w := probe(whsrv.healthz, healthzPath); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("healthz = %d %q, want 200 ok", w.Code, w.Body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	whsrv := newTestServer(t)
+	defer close(whsrv.stopCh)
+	cw, certFile, keyFile, cleanup := newTestCertWatcher(t)
+	defer cleanup()
+	whsrv.certs = cw
+	whsrv.RegisterFactory("kubernetes", informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0))
+	whsrv.GetFactory("kubernetes").Core().V1().ConfigMaps().Informer()
+
+	notReady := func(reasons ...string) {
+		t.Helper()
+		w := probe(whsrv.readyz, readyzPath)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("readyz = %d, want %d", w.Code, http.StatusServiceUnavailable)
+		}
+		if got := strings.TrimSpace(w.Body.String()); got != strings.Join(reasons, "\n") {
+			t.Errorf("readyz reasons = %q, want %q", got, reasons)
+		}
+	}
+	notReady("TLS certificate not loaded", "factory kubernetes not started")
+
+	writeKeyPair(t, certFile, keyFile, 1, time.Now().Add(24*time.Hour), time.Now())
+	if err := cw.Load(); err != nil {
+		t.Fatal(err)
+	}
+	notReady("factory kubernetes not started")
+
+	whsrv.runFactories()
+	if err := waitFor(func() bool { return probe(whsrv.readyz, readyzPath).Code == http.StatusOK }); err != nil {
+		w := probe(whsrv.readyz, readyzPath)
+		t.Errorf("readyz = %d %q, want 200", w.Code, w.Body)
+	}
+}
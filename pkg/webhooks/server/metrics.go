@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/klog"
+)
+
+const (
+	metricsNamespace string = "webhooks"
+
+	outcomeAllowed string = "allowed"
+	outcomeDenied  string = "denied"
+	outcomePatched string = "patched"
+	outcomeError   string = "error"
+)
+
+var (
+	admissionRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "admission",
+			Name:      "requests_total",
+			Help:      "Admission requests served, by path, handler and outcome (allowed, denied, patched, error).",
+		},
+		[]string{"path", "handler", "outcome"},
+	)
+	admissionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "admission",
+			Name:      "request_duration_seconds",
+			Help:      "Time spent by the handlers on the admission requests, by path and handler.",
+			Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"path", "handler"},
+	)
+	admissionDecodeFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "admission",
+			Name:      "decode_failures_total",
+			Help:      "Requests that couldn't be decoded as an AdmissionReview, by path.",
+		},
+		[]string{"path"},
+	)
+	informerCacheSyncedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "informer", "cache_synced"),
+		"Whether the caches of the started informers of the factory are synced (1) or not (0).",
+		[]string{"factory"}, nil,
+	)
+	informerTypeCacheSyncedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "informer", "type_cache_synced"),
+		"Whether the cache of the started informer of the type, or resource, in the factory is synced (1) or not (0).",
+		[]string{"factory", "type"}, nil,
+	)
+)
+
+func init() {
+	prometheus.MustRegister(admissionRequests, admissionDuration, admissionDecodeFailures)
+}
+
+// observeAdmission records the outcome and the latency of an admission request
+func observeAdmission(rh *registeredHandler, resp *admissionV1.AdmissionResponse, elapsed time.Duration) {
+	outcome := outcomeAllowed
+	switch {
+	case resp == nil || (resp.Result != nil && resp.Result.Code >= http.StatusInternalServerError):
+		outcome = outcomeError
+	case !resp.Allowed:
+		outcome = outcomeDenied
+	case len(resp.Patch) > 0:
+		outcome = outcomePatched
+	}
+	admissionRequests.WithLabelValues(rh.path, rh.config.Name, outcome).Inc()
+	admissionDuration.WithLabelValues(rh.path, rh.config.Name).Observe(elapsed.Seconds())
+}
+
+// factoriesCollector reports the informers cache sync status of the
+// factories registered in the server
+type factoriesCollector struct {
+	whsrv *webhookServer
+}
+
+func (fc *factoriesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- informerCacheSyncedDesc
+	ch <- informerTypeCacheSyncedDesc
+}
+
+func (fc *factoriesCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range fc.whsrv.factoryNames() {
+		factorySynced := 1.0
+		for informerType, ok := range fc.whsrv.informersSynced(name) {
+			synced := 1.0
+			if !ok {
+				synced, factorySynced = 0, 0
+			}
+			ch <- prometheus.MustNewConstMetric(informerTypeCacheSyncedDesc, prometheus.GaugeValue, synced,
+				name, informerType)
+		}
+		ch <- prometheus.MustNewConstMetric(informerCacheSyncedDesc, prometheus.GaugeValue, factorySynced, name)
+	}
+}
+
+// startMetricsServer exposes the metrics over plain HTTP on a separate port
+func (whsrv *webhookServer) startMetricsServer() {
+	if whsrv.metricsServer == nil {
+		return
+	}
+	if err := prometheus.Register(&factoriesCollector{whsrv: whsrv}); err != nil {
+		klog.Errorf("Can't register informer factories metrics: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	whsrv.metricsServer.Handler = mux
+
+	go func() {
+		if err := whsrv.metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+			klog.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func TestObserveAdmission(t *testing.T) {
+	tests := []struct {
+		outcome string
+		resp    *admissionV1.AdmissionResponse
+	}{
+		{outcome: outcomeAllowed, resp: &admissionV1.AdmissionResponse{Allowed: true}},
+		{outcome: outcomeDenied, resp: &admissionV1.AdmissionResponse{Allowed: false}},
+		{outcome: outcomePatched, resp: &admissionV1.AdmissionResponse{Allowed: true, Patch: []byte("[]")}},
+		{outcome: outcomeError, resp: nil},
+		{outcome: outcomeError, resp: &admissionV1.AdmissionResponse{
+			Result: &metav1.Status{Code: http.StatusInternalServerError},
+		}},
+	}
+	rh := &registeredHandler{path: "/observe", config: &HandlerConfig{Name: "observe"}}
+	for _, tt := range tests {
+		t.Run(tt.outcome, func(t *testing.T) {
+			counter := admissionRequests.WithLabelValues(rh.path, rh.config.Name, tt.outcome)
+			before := testutil.ToFloat64(counter)
+			observeAdmission(rh, tt.resp, time.Millisecond)
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("%s requests increased by %v, want 1", tt.outcome, got)
+			}
+		})
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	whsrv := newTestServer(t)
+	whsrv.RegisterHandler("/metrics-test", AdmitNever, WithHandlerName("never"))
+
+	denied := admissionRequests.WithLabelValues("/metrics-test", "never", outcomeDenied)
+	before := testutil.ToFloat64(denied)
+	postReview(t, whsrv, "/metrics-test", &admissionV1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  &admissionV1.AdmissionRequest{UID: "uid"},
+	})
+	if got := testutil.ToFloat64(denied) - before; got != 1 {
+		t.Errorf("denied requests increased by %v, want 1", got)
+	}
+
+	failures := admissionDecodeFailures.WithLabelValues("/metrics-test")
+	before = testutil.ToFloat64(failures)
+	postReview(t, whsrv, "/metrics-test", map[string]string{"kind": "Nope"})
+	if got := testutil.ToFloat64(failures) - before; got != 1 {
+		t.Errorf("decode failures increased by %v, want 1", got)
+	}
+}
+
+func TestFactoriesCollector(t *testing.T) {
+	whsrv := newTestServer(t)
+	defer close(whsrv.stopCh)
+	f := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	f.Core().V1().ConfigMaps().Informer()
+	whsrv.RegisterFactory("kubernetes", f)
+
+	f.Start(whsrv.stopCh)
+	f.WaitForCacheSync(whsrv.stopCh)
+
+	want := `
+# HELP webhooks_informer_cache_synced Whether the caches of the started informers of the factory are synced (1) or not (0).
+# TYPE webhooks_informer_cache_synced gauge
+webhooks_informer_cache_synced{factory="kubernetes"} 1
+# HELP webhooks_informer_type_cache_synced Whether the cache of the started informer of the type, or resource, in the factory is synced (1) or not (0).
+# TYPE webhooks_informer_type_cache_synced gauge
+webhooks_informer_type_cache_synced{factory="kubernetes",type="*v1.ConfigMap"} 1
+`
+	if err := testutil.CollectAndCompare(&factoriesCollector{whsrv: whsrv}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// runHandlers calls the handlers of a path in order. Every handler sees the
+// object with the patches of the previous ones applied, the first denial
+// stops the chain and is returned as is, otherwise the patches are merged in
+// a single JSON Patch.
+func (whsrv *webhookServer) runHandlers(rhs []*registeredHandler, ar *AdmissionReview) *admissionV1.AdmissionResponse {
+	if len(rhs) == 1 {
+		return whsrv.runObserved(rhs[0], ar)
+	}
+
+	object := ar.Request.Object.Raw
+	patch := []json.RawMessage{}
+	var auditAnnotations map[string]string
+	var result *metav1.Status
+	for _, rh := range rhs {
+		request := *ar.Request
+		request.Object.Raw = object
+		request.Object.Object = nil
+		resp := whsrv.runObserved(rh, &AdmissionReview{
+			APIVersion: ar.APIVersion,
+			Request:    &request,
+			Path:       ar.Path,
+		})
+		if !resp.Allowed {
+			return resp
+		}
+		if resp.Result != nil {
+			result = resp.Result
+		}
+		for k, v := range resp.AuditAnnotations {
+			if auditAnnotations == nil {
+				auditAnnotations = make(map[string]string)
+			}
+			auditAnnotations[k] = v
+		}
+		if len(resp.Patch) == 0 {
+			continue
+		}
+
+		patched, ops, err := applyPatch(object, resp)
+		if err != nil {
+			klog.Errorf("Can't apply the patch of handler %s on %s (uid: %s): %v",
+				rh.config.Name, ar.Path, ar.Request.UID, err)
+			resp = whsrv.failureResponse(rh, ar, http.StatusInternalServerError, metav1.StatusReasonInternalError,
+				fmt.Sprintf("webhook handler %s returned an invalid patch: %v", rh.config.Name, err))
+			if !resp.Allowed {
+				return resp
+			}
+			continue
+		}
+		object = patched
+		patch = append(patch, ops...)
+	}
+
+	resp := &admissionV1.AdmissionResponse{
+		Allowed:          true,
+		Result:           result,
+		AuditAnnotations: auditAnnotations,
+	}
+	if len(patch) > 0 {
+		merged, err := json.Marshal(patch)
+		if err != nil {
+			klog.Errorf("Can't encode the merged patch for %s: %v", ar.Path, err)
+			return &admissionV1.AdmissionResponse{
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    http.StatusInternalServerError,
+					Reason:  metav1.StatusReasonInternalError,
+					Message: fmt.Sprintf("could not encode the merged patch: %v", err),
+				},
+			}
+		}
+		pt := admissionV1.PatchTypeJSONPatch
+		resp.Patch = merged
+		resp.PatchType = &pt
+	}
+	return resp
+}
+
+// runObserved runs the handler, recording its outcome
+func (whsrv *webhookServer) runObserved(rh *registeredHandler, ar *AdmissionReview) *admissionV1.AdmissionResponse {
+	start := time.Now()
+	resp := whsrv.runHandler(rh, ar)
+	observeAdmission(rh, resp, time.Since(start))
+	return resp
+}
+
+// applyPatch applies the JSON Patch of the response to the object, and
+// returns the patched object along with the operations of the patch
+func applyPatch(object []byte, resp *admissionV1.AdmissionResponse) ([]byte, []json.RawMessage, error) {
+	if resp.PatchType != nil && *resp.PatchType != admissionV1.PatchTypeJSONPatch {
+		return nil, nil, fmt.Errorf("unsupported patch type %s", *resp.PatchType)
+	}
+	if len(object) == 0 {
+		return nil, nil, fmt.Errorf("no object to patch")
+	}
+	var ops []json.RawMessage
+	if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+		return nil, nil, err
+	}
+	p, err := jsonpatch.DecodePatch(resp.Patch)
+	if err != nil {
+		return nil, nil, err
+	}
+	patched, err := p.Apply(object)
+	if err != nil {
+		return nil, nil, err
+	}
+	return patched, ops, nil
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func patching(patch string, seen *[]string) AdmissionHandler {
+	return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+		*seen = append(*seen, string(ar.Request.Object.Raw))
+		pt := admissionV1.PatchTypeJSONPatch
+		return &admissionV1.AdmissionResponse{Allowed: true, Patch: []byte(patch), PatchType: &pt}
+	}
+}
+
+func newPatchReview(object string) *AdmissionReview {
+	return &AdmissionReview{Request: &admissionV1.AdmissionRequest{
+		UID:    "uid",
+		Object: runtime.RawExtension{Raw: []byte(object)},
+	}}
+}
+
+func TestRunHandlersMergePatches(t *testing.T) {
+	whsrv := newTestServer(t)
+	var seen []string
+	whsrv.RegisterHandler("/mutate", patching(`[{"op":"add","path":"/b","value":2}]`, &seen), WithHandlerName("a"))
+	whsrv.RegisterHandler("/mutate", func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+		return &admissionV1.AdmissionResponse{Allowed: true, AuditAnnotations: map[string]string{"checked": "yes"}}
+	}, WithHandlerName("b"))
+	whsrv.RegisterHandler("/mutate", patching(`[{"op":"replace","path":"/b","value":3}]`, &seen), WithHandlerName("c"))
+
+	object := `{"a":1}`
+	resp := whsrv.runHandlers(whsrv.lookupHandlers("/mutate"), newPatchReview(object))
+	if !resp.Allowed || resp.AuditAnnotations["checked"] != "yes" {
+		t.Fatalf("response = %+v, want allowed with the audit annotations", resp)
+	}
+	// every handler sees the patches of the previous ones
+	if len(seen) != 2 || seen[0] != object || seen[1] != `{"a":1,"b":2}` {
+		t.Errorf("objects seen by the handlers = %v", seen)
+	}
+	if resp.PatchType == nil || *resp.PatchType != admissionV1.PatchTypeJSONPatch {
+		t.Errorf("patch type = %v, want %s", resp.PatchType, admissionV1.PatchTypeJSONPatch)
+	}
+	p, err := jsonpatch.DecodePatch(resp.Patch)
+	if err != nil {
+		t.Fatalf("merged patch %s: %v", resp.Patch, err)
+	}
+	patched, err := p.Apply([]byte(object))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !jsonpatch.Equal(patched, []byte(`{"a":1,"b":3}`)) {
+		t.Errorf("patched object = %s", patched)
+	}
+}
+
+func TestRunHandlersDenial(t *testing.T) {
+	whsrv := newTestServer(t)
+	var seen []string
+	whsrv.RegisterHandler("/mutate", patching(`[{"op":"add","path":"/b","value":2}]`, &seen), WithHandlerName("a"))
+	whsrv.RegisterHandler("/mutate", AdmitNever, WithHandlerName("never"))
+	whsrv.RegisterHandler("/mutate", patching(`[]`, &seen), WithHandlerName("c"))
+
+	resp := whsrv.runHandlers(whsrv.lookupHandlers("/mutate"), newPatchReview(`{}`))
+	if resp.Allowed || len(resp.Patch) != 0 {
+		t.Errorf("response = %+v, want the denial without patch", resp)
+	}
+	if len(seen) != 1 {
+		t.Errorf("%d handlers called, the chain should stop at the denial", len(seen))
+	}
+}
+
+func TestRunHandlersInvalidPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      FailurePolicy
+		wantAllowed bool
+	}{
+		{name: "allow skips the patch", policy: FailurePolicyAllow, wantAllowed: true},
+		{name: "deny", policy: FailurePolicyDeny, wantAllowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whsrv := newTestServer(t)
+			var seen []string
+			whsrv.RegisterHandler("/mutate", patching(`[{"op":"remove","path":"/missing"}]`, &seen),
+				WithHandlerName("invalid"), WithFailurePolicy(tt.policy))
+			whsrv.RegisterHandler("/mutate", patching(`[{"op":"add","path":"/b","value":2}]`, &seen), WithHandlerName("b"))
+
+			resp := whsrv.runHandlers(whsrv.lookupHandlers("/mutate"), newPatchReview(`{"a":1}`))
+			if resp.Allowed != tt.wantAllowed {
+				t.Fatalf("response = %+v, want allowed %v", resp, tt.wantAllowed)
+			}
+			if !tt.wantAllowed {
+				return
+			}
+			var ops []map[string]interface{}
+			if err := json.Unmarshal(resp.Patch, &ops); err != nil {
+				t.Fatal(err)
+			}
+			if len(ops) != 1 || ops[0]["path"] != "/b" {
+				t.Errorf("patch = %s, want only the valid one", resp.Patch)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// setAdmitPolicies validates and applies the admit policies, the current ones
+// stay in place when they're invalid
+func (whsrv *webhookServer) setAdmitPolicies(defaultPolicy string, rules []AdmitPolicyRule) error {
+	ap, err := NewAdmitPolicies(defaultPolicy, rules)
+	if err != nil {
+		return err
+	}
+	whsrv.admitPolicies.Store(ap)
+	return nil
+}
+
+// admitPolicy returns the admit policy applying to the request, and where it
+// comes from
+func (whsrv *webhookServer) admitPolicy(ar *AdmissionReview) (AdmitPolicy, string) {
+	ap, _ := whsrv.admitPolicies.Load().(*AdmitPolicies)
+	if ap == nil {
+		ap = &AdmitPolicies{Default: AdmitPolicyAlways}
+	}
+	namespace := ""
+	if ar.Request != nil {
+		namespace = ar.Request.Namespace
+	}
+	policy, rule := ap.For(ar.Path, namespace)
+	if rule == nil {
+		return policy, fmt.Sprintf("the default admit policy %s", policy)
+	}
+	return policy, "the " + rule.String()
+}
+
+// admitByPolicy answers the requests no handler is registered or matching for
+func (whsrv *webhookServer) admitByPolicy(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+	policy, source := whsrv.admitPolicy(ar)
+	if policy == AdmitPolicyNever {
+		return ErrorResponse(http.StatusForbidden, metav1.StatusReasonForbidden,
+			fmt.Sprintf("no webhook handler registered or matching for %s, rejected by %s", ar.Path, source))
+	}
+	return &admissionV1.AdmissionResponse{
+		Allowed: true,
+		Result: &metav1.Status{
+			Status:  metav1.StatusSuccess,
+			Code:    http.StatusOK,
+			Message: fmt.Sprintf("no webhook handler registered or matching for %s, admitted by %s", ar.Path, source),
+		},
+	}
+}
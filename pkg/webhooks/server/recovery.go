@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// runHandler calls the handler wrapped by the middlewares, recovering from
+// panics and enforcing its deadline, in both cases (and when the handler
+// returns no response) the answer follows the handler failure policy
+func (whsrv *webhookServer) runHandler(rh *registeredHandler, ar *AdmissionReview) *admissionV1.AdmissionResponse {
+	ar.Handler = rh.config.Name
+	timeout := rh.config.Timeout
+	if timeout == 0 {
+		timeout = whsrv.config.HandlerTimeout
+	}
+
+	h := Chain(rh.handler, whsrv.middlewares(rh)...)
+	recovery := Recovery(func(ar *AdmissionReview, r interface{}) *admissionV1.AdmissionResponse {
+		return whsrv.failureResponse(rh, ar, http.StatusInternalServerError, metav1.StatusReasonInternalError,
+			fmt.Sprintf("webhook handler %s failed: %v", rh.config.Name, r))
+	})
+
+	// buffered, so a late handler doesn't leak blocked on send
+	done := make(chan *admissionV1.AdmissionResponse, 1)
+	go func() {
+		done <- recovery(h)(ar)
+	}()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	select {
+	case resp := <-done:
+		if resp == nil {
+			resp = whsrv.failureResponse(rh, ar, http.StatusInternalServerError, metav1.StatusReasonInternalError,
+				fmt.Sprintf("webhook handler %s returned no response", rh.config.Name))
+		}
+		return resp
+	case <-deadline:
+		klog.Errorf("Handler %s timed out after %v serving %s (uid: %s)",
+			rh.config.Name, timeout, rh.path, ar.Request.UID)
+		return whsrv.failureResponse(rh, ar, http.StatusGatewayTimeout, metav1.StatusReasonTimeout,
+			fmt.Sprintf("webhook handler %s didn't answer in %v", rh.config.Name, timeout))
+	}
+}
+
+func (whsrv *webhookServer) failureResponse(rh *registeredHandler, ar *AdmissionReview, code int32, reason metav1.StatusReason, message string) *admissionV1.AdmissionResponse {
+	allowed := true
+	switch rh.config.FailurePolicy {
+	case FailurePolicyDeny:
+		allowed = false
+	case FailurePolicyAllow:
+	default:
+		policy, _ := whsrv.admitPolicy(ar)
+		allowed = policy != AdmitPolicyNever
+	}
+	return &admissionV1.AdmissionResponse{
+		Allowed: allowed,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  reason,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func TestRunHandler(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	panicking := func(*AdmissionReview) *admissionV1.AdmissionResponse { panic("boom") }
+	blocking := func(*AdmissionReview) *admissionV1.AdmissionResponse {
+		<-release
+		return &admissionV1.AdmissionResponse{Allowed: true}
+	}
+
+	tests := []struct {
+		name          string
+		handler       AdmissionHandler
+		opts          []HandlerOption
+		defaultPolicy string
+		wantAllowed   bool
+		wantCode      int32
+		wantReason    metav1.StatusReason
+	}{
+		{
+			name:        "answer",
+			handler:     AdmitNever,
+			wantAllowed: false,
+		},
+		{
+			name:          "panic follows the default admit policy",
+			handler:       panicking,
+			defaultPolicy: "Never",
+			wantAllowed:   false,
+			wantCode:      http.StatusInternalServerError,
+			wantReason:    metav1.StatusReasonInternalError,
+		},
+		{
+			name:          "panic with the Allow failure policy",
+			handler:       panicking,
+			opts:          []HandlerOption{WithFailurePolicy(FailurePolicyAllow)},
+			defaultPolicy: "Never",
+			wantAllowed:   true,
+			wantCode:      http.StatusInternalServerError,
+			wantReason:    metav1.StatusReasonInternalError,
+		},
+		{
+			name:          "timeout with the Deny failure policy",
+			handler:       blocking,
+			opts:          []HandlerOption{WithTimeout(10 * time.Millisecond), WithFailurePolicy(FailurePolicyDeny)},
+			defaultPolicy: "Always",
+			wantAllowed:   false,
+			wantCode:      http.StatusGatewayTimeout,
+			wantReason:    metav1.StatusReasonTimeout,
+		},
+		{
+			name:          "timeout of the server",
+			handler:       blocking,
+			defaultPolicy: "Always",
+			wantAllowed:   true,
+			wantCode:      http.StatusGatewayTimeout,
+			wantReason:    metav1.StatusReasonTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whsrv := newTestServer(t)
+			whsrv.config.HandlerTimeout = 10 * time.Millisecond
+			if tt.defaultPolicy != "" {
+				if err := whsrv.setAdmitPolicies(tt.defaultPolicy, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rh := &registeredHandler{path: "/p", handler: tt.handler, config: NewHandlerConfig(tt.handler, tt.opts...)}
+
+			resp := whsrv.runHandler(rh, &AdmissionReview{Request: &admissionV1.AdmissionRequest{UID: "uid"}})
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("allowed = %v, want %v", resp.Allowed, tt.wantAllowed)
+			}
+			if tt.wantCode == 0 {
+				if resp.Result != nil {
+					t.Errorf("result = %+v, want the handler answer", resp.Result)
+				}
+				return
+			}
+			if resp.Result == nil || resp.Result.Code != tt.wantCode || resp.Result.Reason != tt.wantReason {
+				t.Errorf("result = %+v, want code %d reason %s", resp.Result, tt.wantCode, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestRunHandlerMiddlewares(t *testing.T) {
+	var trace []string
+	tracing := func(name string) Middleware {
+		return func(next AdmissionHandler) AdmissionHandler {
+			return func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+				trace = append(trace, name+" "+ar.Handler)
+				return next(ar)
+			}
+		}
+	}
+	whsrv := newTestServer(t)
+	whsrv.Use(tracing("global"))
+	h := func(*AdmissionReview) *admissionV1.AdmissionResponse {
+		trace = append(trace, "handler")
+		panic("boom")
+	}
+	rh := &registeredHandler{path: "/p", handler: h,
+		config: NewHandlerConfig(h, WithHandlerName("h"), WithHandlerMiddleware(tracing("own")))}
+
+	// the panic is recovered outside all the middlewares
+	resp := whsrv.runHandler(rh, &AdmissionReview{Request: &admissionV1.AdmissionRequest{UID: "uid"}})
+	if resp.Result == nil || resp.Result.Code != http.StatusInternalServerError {
+		t.Errorf("response = %+v, want the failure policy answer", resp)
+	}
+	want := []string{"global h", "own h", "handler"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("calls = %v, want %v", trace, want)
+	}
+}
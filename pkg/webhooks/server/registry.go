@@ -0,0 +1,164 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// routingTable is an immutable snapshot of the registered handlers, the paths
+// matched by prefix being sorted longest first, and of the middlewares
+// wrapping all of them
+type routingTable struct {
+	handlers    map[string][]*registeredHandler
+	prefixes    []string
+	middlewares []Middleware
+}
+
+func (rt *routingTable) lookup(path string) ([]*registeredHandler, bool) {
+	// try exact path match (faster)
+	if rhs, ok := rt.handlers[path]; ok {
+		return rhs, true
+	}
+	for _, prefix := range rt.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return rt.handlers[prefix], true
+		}
+	}
+	return nil, false
+}
+
+// handlerRegistry holds the handlers by path and the server-wide middlewares.
+// The changes are serialized and publish a new routing table, the lookups read
+// the current table without locking, so the requests in flight keep the
+// handlers they started with.
+type handlerRegistry struct {
+	lock        sync.Mutex
+	handlers    map[string][]*registeredHandler
+	middlewares []Middleware
+	table       atomic.Value // *routingTable
+}
+
+func (hr *handlerRegistry) lookup(path string) ([]*registeredHandler, bool) {
+	rt, _ := hr.table.Load().(*routingTable)
+	if rt == nil {
+		return nil, false
+	}
+	return rt.lookup(path)
+}
+
+// globalMiddlewares returns the server-wide middlewares, they must not be
+// modified
+func (hr *handlerRegistry) globalMiddlewares() []Middleware {
+	rt, _ := hr.table.Load().(*routingTable)
+	if rt == nil {
+		return nil
+	}
+	return rt.middlewares
+}
+
+// use appends the middlewares to the server-wide ones
+func (hr *handlerRegistry) use(mws ...Middleware) {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	updated := make([]Middleware, len(hr.middlewares), len(hr.middlewares)+len(mws))
+	copy(updated, hr.middlewares)
+	hr.middlewares = append(updated, mws...)
+	hr.publish()
+}
+
+// register appends the handler to the ones of the path
+func (hr *handlerRegistry) register(path string, h AdmissionHandler, opts ...HandlerOption) error {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	config := NewHandlerConfig(h, opts...)
+	rhs := hr.handlers[path]
+	if indexOf(rhs, config.Name) >= 0 {
+		return errors.New(fmt.Sprintf("Handler %s for path: %s already exists", config.Name, path))
+	}
+	updated := make([]*registeredHandler, len(rhs), len(rhs)+1)
+	copy(updated, rhs)
+	hr.update(path, append(updated, &registeredHandler{path: path, handler: h, config: config}))
+	return nil
+}
+
+// unregister removes the handler with the given name from the ones of the path
+func (hr *handlerRegistry) unregister(path, name string) error {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	rhs := hr.handlers[path]
+	i := indexOf(rhs, name)
+	if i < 0 {
+		return errors.New(fmt.Sprintf("Handler %s for path: %s doesn't exist", name, path))
+	}
+	hr.update(path, append(rhs[:i:i], rhs[i+1:]...))
+	return nil
+}
+
+// replace swaps the handler of the path having the same name, keeping its
+// place in the chain
+func (hr *handlerRegistry) replace(path string, h AdmissionHandler, opts ...HandlerOption) error {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	config := NewHandlerConfig(h, opts...)
+	rhs := hr.handlers[path]
+	i := indexOf(rhs, config.Name)
+	if i < 0 {
+		return errors.New(fmt.Sprintf("Handler %s for path: %s doesn't exist", config.Name, path))
+	}
+	updated := make([]*registeredHandler, len(rhs))
+	copy(updated, rhs)
+	updated[i] = &registeredHandler{path: path, handler: h, config: config}
+	hr.update(path, updated)
+	return nil
+}
+
+// update sets the handlers of the path and publishes the new routing table,
+// with the lock held
+func (hr *handlerRegistry) update(path string, rhs []*registeredHandler) {
+	if hr.handlers == nil {
+		hr.handlers = make(map[string][]*registeredHandler)
+	}
+	if len(rhs) == 0 {
+		delete(hr.handlers, path)
+	} else {
+		hr.handlers[path] = rhs
+	}
+	hr.publish()
+}
+
+// publish stores a new routing table made of the handlers and middlewares,
+// with the lock held
+func (hr *handlerRegistry) publish() {
+	rt := &routingTable{
+		handlers:    make(map[string][]*registeredHandler, len(hr.handlers)),
+		prefixes:    make([]string, 0, len(hr.handlers)),
+		middlewares: hr.middlewares,
+	}
+	for p, rhs := range hr.handlers {
+		rt.handlers[p] = rhs
+		rt.prefixes = append(rt.prefixes, p)
+	}
+	// longer is better, then alphabetical so the order doesn't change
+	sort.Slice(rt.prefixes, func(i, j int) bool {
+		if len(rt.prefixes[i]) != len(rt.prefixes[j]) {
+			return len(rt.prefixes[i]) > len(rt.prefixes[j])
+		}
+		return rt.prefixes[i] < rt.prefixes[j]
+	})
+	hr.table.Store(rt)
+}
+
+func indexOf(rhs []*registeredHandler, name string) int {
+	for i, rh := range rhs {
+		if rh.config.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func TestHandlerRegistryLookup(t *testing.T) {
+	hr := &handlerRegistry{}
+	for _, path := range []string{"/", "/a", "/a/b", "/ab", "/b"} {
+		if err := hr.register(path, AdmitAlways, WithHandlerName(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path  string
+		want  string
+		found bool
+	}{
+		{path: "/a", want: "/a", found: true},
+		{path: "/a/b/c", want: "/a/b", found: true},
+		{path: "/a/c", want: "/a", found: true},
+		{path: "/abc", want: "/ab", found: true},
+		{path: "/b/c", want: "/b", found: true},
+		{path: "/c", want: "/", found: true},
+		{path: "nope", found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rhs, found := hr.lookup(tt.path)
+			if found != tt.found {
+				t.Fatalf("lookup(%s) found = %v, want %v", tt.path, found, tt.found)
+			}
+			if found && rhs[0].config.Name != tt.want {
+				t.Errorf("lookup(%s) = %s, want %s", tt.path, rhs[0].config.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerRegistryPrefixesOrder(t *testing.T) {
+	hr := &handlerRegistry{}
+	for _, path := range []string{"/b", "/a", "/ccc", "/", "/dd"} {
+		hr.register(path, AdmitAlways)
+	}
+	rt := hr.table.Load().(*routingTable)
+	want := []string{"/ccc", "/dd", "/a", "/b", "/"}
+	if !reflect.DeepEqual(rt.prefixes, want) {
+		t.Errorf("prefixes = %v, want %v", rt.prefixes, want)
+	}
+}
+
+func TestHandlerRegistryChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(hr *handlerRegistry) error
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "register appends",
+			change: func(hr *handlerRegistry) error {
+				return hr.register("/p", AdmitAlways, WithHandlerName("d"))
+			},
+			want: []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "register a taken name",
+			change: func(hr *handlerRegistry) error {
+				return hr.register("/p", AdmitAlways, WithHandlerName("b"))
+			},
+			want:    []string{"a", "b", "c"},
+			wantErr: true,
+		},
+		{
+			name: "unregister keeps the order",
+			change: func(hr *handlerRegistry) error {
+				return hr.unregister("/p", "b")
+			},
+			want: []string{"a", "c"},
+		},
+		{
+			name: "unregister a missing handler",
+			change: func(hr *handlerRegistry) error {
+				return hr.unregister("/p", "z")
+			},
+			want:    []string{"a", "b", "c"},
+			wantErr: true,
+		},
+		{
+			name: "replace keeps the place",
+			change: func(hr *handlerRegistry) error {
+				return hr.replace("/p", AdmitNever, WithHandlerName("b"))
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "replace a missing handler",
+			change: func(hr *handlerRegistry) error {
+				return hr.replace("/p", AdmitNever, WithHandlerName("z"))
+			},
+			want:    []string{"a", "b", "c"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr := &handlerRegistry{}
+			for _, name := range []string{"a", "b", "c"} {
+				hr.register("/p", AdmitAlways, WithHandlerName(name))
+			}
+			before, _ := hr.lookup("/p")
+			snapshot := append([]*registeredHandler(nil), before...)
+
+			err := tt.change(hr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			rhs, _ := hr.lookup("/p")
+			if got := handlerNames(rhs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handlers = %v, want %v", got, tt.want)
+			}
+			// the handlers looked up before the change are left untouched
+			if !reflect.DeepEqual(before, snapshot) {
+				t.Errorf("handlers looked up before the change = %v, want %v",
+					handlerNames(before), handlerNames(snapshot))
+			}
+		})
+	}
+}
+
+func TestHandlerRegistryUnregisterLast(t *testing.T) {
+	hr := &handlerRegistry{}
+	hr.register("/p", AdmitAlways, WithHandlerName("a"))
+	if err := hr.unregister("/p", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := hr.lookup("/p"); found {
+		t.Error("path without handlers still routed")
+	}
+}
+
+func TestHandlerRegistryMiddlewares(t *testing.T) {
+	hr := &handlerRegistry{}
+	if mws := hr.globalMiddlewares(); len(mws) != 0 {
+		t.Fatalf("globalMiddlewares() = %d middlewares, want none", len(mws))
+	}
+	noop := func(h AdmissionHandler) AdmissionHandler { return h }
+	hr.use(noop)
+	before := hr.globalMiddlewares()
+	hr.use(noop, noop)
+	if len(before) != 1 {
+		t.Errorf("middlewares read before use() = %d, want 1", len(before))
+	}
+	if mws := hr.globalMiddlewares(); len(mws) != 3 {
+		t.Errorf("globalMiddlewares() = %d middlewares, want 3", len(mws))
+	}
+}
+
+func TestHandlerRegistryConcurrency(t *testing.T) {
+	hr := &handlerRegistry{}
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c", "d"} {
+		wg.Add(2)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				hr.register("/p", AdmitAlways, WithHandlerName(name))
+				hr.unregister("/p", name)
+			}
+		}(name)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				hr.lookup("/p/x")
+				hr.globalMiddlewares()
+			}
+		}()
+	}
+	wg.Wait()
+	if _, found := hr.lookup("/p"); found {
+		t.Error("handlers left after unregistering all of them")
+	}
+}
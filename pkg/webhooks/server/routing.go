@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// selectHandlers keeps the handlers whose Match selects the request, when
+// none does the default admit policy applies
+func (whsrv *webhookServer) selectHandlers(rhs []*registeredHandler, ar *AdmissionReview) []*registeredHandler {
+	selected := make([]*registeredHandler, 0, len(rhs))
+	for _, rh := range rhs {
+		if rh.config.Match == nil {
+			selected = append(selected, rh)
+			continue
+		}
+		matches, err := rh.config.Match.Matches(ar.Request, whsrv.namespaceLabels)
+		if err != nil {
+			// can't tell, the failure policy of the handler decides
+			klog.Errorf("Can't match handler %s on %s (uid: %s): %v",
+				rh.config.Name, rh.path, ar.Request.UID, err)
+			selected = append(selected, whsrv.unmatchableHandler(rh, err))
+			continue
+		}
+		if matches {
+			selected = append(selected, rh)
+		}
+	}
+	if len(selected) == 0 {
+		return []*registeredHandler{whsrv.defaultHandler(rhs[0].path)}
+	}
+	return selected
+}
+
+func (whsrv *webhookServer) unmatchableHandler(rh *registeredHandler, err error) *registeredHandler {
+	failing := &registeredHandler{
+		path:   rh.path,
+		config: &HandlerConfig{Name: rh.config.Name, FailurePolicy: rh.config.FailurePolicy},
+	}
+	failing.handler = func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+		return whsrv.failureResponse(rh, ar, http.StatusInternalServerError, metav1.StatusReasonInternalError,
+			fmt.Sprintf("can't tell if webhook handler %s matches the request: %v", rh.config.Name, err))
+	}
+	return failing
+}
+
+// WithNamespaceLister gives the namespace labels to the handlers matching on
+// a NamespaceSelector, the informer of the lister must be started
+func WithNamespaceLister(lister corelisters.NamespaceLister) WebhookServerOption {
+	return func(whsrv WebhookServer) WebhookServer {
+		if ws, ok := whsrv.(*webhookServer); ok {
+			ws.namespaceLabels = func(name string) (map[string]string, error) {
+				ns, err := lister.Get(name)
+				if err != nil {
+					return nil, err
+				}
+				return ns.Labels, nil
+			}
+		}
+		return whsrv
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+var podKind = metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}
+
+func handlerNames(rhs []*registeredHandler) []string {
+	names := make([]string, 0, len(rhs))
+	for _, rh := range rhs {
+		names = append(names, rh.config.Name)
+	}
+	return names
+}
+
+func TestSelectHandlers(t *testing.T) {
+	whsrv := newTestServer(t)
+	whsrv.RegisterHandler("/mutate", AdmitAlways, WithHandlerName("all"))
+	whsrv.RegisterHandler("/mutate", AdmitAlways, WithHandlerName("pods"),
+		WithMatch(Match{Kinds: []metav1.GroupVersionKind{podKind}}))
+	whsrv.RegisterHandler("/mutate", AdmitAlways, WithHandlerName("deletes"),
+		WithMatch(Match{Operations: []admissionV1.Operation{admissionV1.Delete}}))
+
+	rhs := whsrv.lookupHandlers("/mutate")
+	got := handlerNames(whsrv.selectHandlers(rhs, &AdmissionReview{
+		Request: &admissionV1.AdmissionRequest{Kind: podKind, Operation: admissionV1.Create},
+	}))
+	if len(got) != 2 || got[0] != "all" || got[1] != "pods" {
+		t.Errorf("selected handlers = %v, want [all pods]", got)
+	}
+}
+
+func TestSelectHandlersDefaultAdmitPolicy(t *testing.T) {
+	for _, policy := range []string{"Always", "Never"} {
+		t.Run(policy, func(t *testing.T) {
+			whsrv := newTestServer(t)
+			if err := whsrv.setAdmitPolicies(policy, nil); err != nil {
+				t.Fatal(err)
+			}
+			whsrv.RegisterHandler("/mutate", AdmitAlways, WithHandlerName("deletes"),
+				WithMatch(Match{Operations: []admissionV1.Operation{admissionV1.Delete}}))
+
+			// the path is registered but no handler matches the request
+			ar := &AdmissionReview{Request: &admissionV1.AdmissionRequest{Kind: podKind, Operation: admissionV1.Create}}
+			rhs := whsrv.selectHandlers(whsrv.lookupHandlers("/mutate"), ar)
+			if len(rhs) != 1 || rhs[0].config.Name != defaultHandlerName || rhs[0].path != "/mutate" {
+				t.Fatalf("selected handlers = %v, want the default admit policy on /mutate", handlerNames(rhs))
+			}
+			if resp := whsrv.runHandlers(rhs, ar); resp.Allowed != (policy == "Always") {
+				t.Errorf("allowed = %v with DefaultAdmitPolicy %s", resp.Allowed, policy)
+			}
+		})
+	}
+}
+
+func TestSelectHandlersMatchError(t *testing.T) {
+	whsrv := newTestServer(t)
+	whsrv.namespaceLabels = func(string) (map[string]string, error) { return nil, errors.New("unavailable") }
+	whsrv.RegisterHandler("/validate", AdmitAlways, WithHandlerName("selected"), WithFailurePolicy(FailurePolicyDeny),
+		WithMatch(Match{NamespaceSelector: labels.SelectorFromSet(labels.Set{"env": "prod"})}))
+
+	ar := &AdmissionReview{Request: &admissionV1.AdmissionRequest{Kind: podKind, Namespace: "prod"}}
+	rhs := whsrv.selectHandlers(whsrv.lookupHandlers("/validate"), ar)
+	// the failure policy of the handler decides
+	if resp := whsrv.runHandlers(rhs, ar); resp.Allowed {
+		t.Errorf("response = %+v, want denied by the failure policy", resp)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+// ScopedFactory returns the factory of the scope, built from the shared client
+// and registered under the name of the scope the first time, so the plugins
+// asking for the same scope share it. The cluster-wide scope is the kubernetes
+// factory.
+func (whsrv *webhookServer) ScopedFactory(scope FactoryScope) (informers.SharedInformerFactory, error) {
+	if err := scope.Validate(); err != nil {
+		return nil, err
+	}
+	cs, err := whsrv.KubeClient()
+	if err != nil {
+		return nil, err
+	}
+	name := scope.FactoryName(KubernetesFactoryName)
+	f := whsrv.GetOrCreateFactory(name, func() informers.SharedInformerFactory {
+		return informers.NewSharedInformerFactoryWithOptions(cs, scope.Resync,
+			informers.WithNamespace(scope.Namespace),
+			informers.WithTweakListOptions(scope.TweakListOptions))
+	})
+	if f == nil {
+		return nil, errors.New(fmt.Sprintf("Factory %s is a dynamic factory", name))
+	}
+	return f, nil
+}
+
+// ScopedDynamicFactory returns the dynamic factory of the scope, built from
+// the shared dynamic client and registered under the name of the scope the
+// first time. The cluster-wide scope is the dynamic factory.
+func (whsrv *webhookServer) ScopedDynamicFactory(scope FactoryScope) (dynamicinformer.DynamicSharedInformerFactory, error) {
+	if err := scope.Validate(); err != nil {
+		return nil, err
+	}
+	dc, err := whsrv.DynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	name := scope.FactoryName(DynamicFactoryName)
+	f := whsrv.GetOrCreateDynamicFactory(name, func() dynamicinformer.DynamicSharedInformerFactory {
+		return dynamicinformer.NewFilteredDynamicSharedInformerFactory(dc, scope.Resync,
+			scope.Namespace, scope.TweakListOptions)
+	})
+	if f == nil {
+		return nil, errors.New(fmt.Sprintf("Factory %s is a typed factory", name))
+	}
+	return f, nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func TestScopedFactory(t *testing.T) {
+	whsrv := newTestServer(t)
+	defer close(whsrv.stopCh)
+	whsrv.clients.kube = fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "system"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "other"}},
+	)
+	scope := FactoryScope{Namespace: "kube-system"}
+
+	f, err := whsrv.ScopedFactory(scope)
+	if err != nil {
+		t.Fatalf("ScopedFactory() error = %v", err)
+	}
+	if again, _ := whsrv.ScopedFactory(FactoryScope{Namespace: "kube-system"}); again != f {
+		t.Error("ScopedFactory() of the same scope returned another factory")
+	}
+	if whsrv.GetFactory("kubernetes:namespace=kube-system") != f {
+		t.Error("scoped factory not registered under the name of its scope")
+	}
+	if cluster, _ := whsrv.ScopedFactory(FactoryScope{}); cluster == f || whsrv.GetFactory(KubernetesFactoryName) != cluster {
+		t.Error("ScopedFactory() of the cluster-wide scope should be the kubernetes factory")
+	}
+
+	// the informers of the factory watch the namespace only
+	lister := f.Core().V1().ConfigMaps().Lister()
+	whsrv.runFactories()
+	if err := waitFor(func() bool { return whsrv.informersSynced(scope.FactoryName(KubernetesFactoryName))["*v1.ConfigMap"] }); err != nil {
+		t.Fatal("ConfigMaps informer of the scope not synced")
+	}
+	cms, err := lister.List(labels.Everything())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cms) != 1 || cms[0].Name != "system" {
+		t.Errorf("ConfigMaps of the scope = %v, want kube-system/system only", cms)
+	}
+}
+
+func TestScopedFactoryErrors(t *testing.T) {
+	whsrv := newTestServer(t)
+	whsrv.clients.kube = fake.NewSimpleClientset()
+	whsrv.clients.dynamic = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+
+	if _, err := whsrv.ScopedFactory(FactoryScope{LabelSelector: "app in (web"}); err == nil {
+		t.Error("ScopedFactory() of an invalid scope should fail")
+	}
+	if _, err := whsrv.ScopedDynamicFactory(FactoryScope{FieldSelector: "metadata.name"}); err == nil {
+		t.Error("ScopedDynamicFactory() of an invalid scope should fail")
+	}
+
+	// a scope name taken by a factory of the other kind
+	scope := FactoryScope{Namespace: "taken"}
+	whsrv.RegisterFactory(scope.FactoryName(DynamicFactoryName), informers.NewSharedInformerFactory(whsrv.clients.kube, 0))
+	if _, err := whsrv.ScopedDynamicFactory(scope); err == nil {
+		t.Error("ScopedDynamicFactory() of a name taken by a typed factory should fail")
+	}
+	if f, err := whsrv.ScopedDynamicFactory(FactoryScope{Namespace: "free"}); err != nil || f == nil {
+		t.Errorf("ScopedDynamicFactory() = %v, %v, want a factory", f, err)
+	}
+}
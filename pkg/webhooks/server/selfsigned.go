@@ -0,0 +1,399 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+const (
+	selfSignedCertValidity     time.Duration = 365 * 24 * time.Hour
+	selfSignedCertRotateBefore time.Duration = 90 * 24 * time.Hour
+	selfSignedCheckInterval    time.Duration = time.Minute
+
+	secretCAKey string = "ca.crt"
+)
+
+// certSource provides the serving certificate to the TLS listener
+type certSource interface {
+	GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	Loaded() bool
+	NotAfter() time.Time
+	Run(stopCh <-chan struct{})
+}
+
+var _ certSource = &certWatcher{}
+var _ certSource = &selfSignedCerts{}
+
+// selfSignedCerts manages a self-signed CA and a serving certificate signed
+// by it. Both are stored in a Secret so every replica serves the same pair,
+// the CA bundle is injected into the configured Mutating and Validating
+// WebhookConfigurations and everything is rotated before the expiration.
+// The CA bundle keeps the previous CA while it's valid, so the replicas still
+// serving the old pair are trusted until they pick up the new one.
+type selfSignedCerts struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	dnsNames  []string
+
+	mutatingConfigs   []string
+	validatingConfigs []string
+
+	now  func() time.Time
+	cert atomic.Value // *tls.Certificate
+}
+
+func newSelfSignedCerts(client kubernetes.Interface, config *WebhookServerConfig) *selfSignedCerts {
+	svc, ns := config.ServiceName, config.CertsSecretNamespace
+	return &selfSignedCerts{
+		client:    client,
+		namespace: ns,
+		name:      config.CertsSecretName,
+		dnsNames: []string{
+			svc,
+			fmt.Sprintf("%s.%s", svc, ns),
+			fmt.Sprintf("%s.%s.svc", svc, ns),
+			fmt.Sprintf("%s.%s.svc.cluster.local", svc, ns),
+		},
+		mutatingConfigs:   config.MutatingWebhookConfigurations,
+		validatingConfigs: config.ValidatingWebhookConfigurations,
+		now:               time.Now,
+	}
+}
+
+func (sc *selfSignedCerts) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, ok := sc.cert.Load().(*tls.Certificate); ok {
+		return cert, nil
+	}
+	return nil, errors.New("no TLS certificate loaded")
+}
+
+func (sc *selfSignedCerts) Loaded() bool {
+	_, ok := sc.cert.Load().(*tls.Certificate)
+	return ok
+}
+
+func (sc *selfSignedCerts) NotAfter() time.Time {
+	if cert, ok := sc.cert.Load().(*tls.Certificate); ok && cert.Leaf != nil {
+		return cert.Leaf.NotAfter
+	}
+	return time.Time{}
+}
+
+// Run keeps the Secret, the served pair and the CA bundles in sync until
+// stopCh is closed
+func (sc *selfSignedCerts) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(selfSignedCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := sc.Sync(); err != nil {
+			klog.Errorf("Can't sync self-signed certificates: %v", err)
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync makes sure the Secret holds a valid pair, generating a new CA and
+// certificate when missing or close to expiration, then updates the CA
+// bundles and loads the pair. A new pair is served only once the bundles
+// trust its CA, until then the old one is kept.
+func (sc *selfSignedCerts) Sync() error {
+	secret, err := sc.ensureSecret()
+	if err != nil {
+		return err
+	}
+	pair, err := parseKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return err
+	}
+	old, loaded := sc.cert.Load().(*tls.Certificate)
+	changed := !loaded || !sameCertificate(old, pair)
+	err = sc.injectCABundle(secret.Data[secretCAKey])
+	if err != nil && loaded {
+		if changed {
+			klog.Warningf("Keeping the self-signed certificate (serial: %v) until the CA bundles are updated",
+				old.Leaf.SerialNumber)
+		}
+		return err
+	}
+	// with nothing served yet, the new pair is loaded even if the bundles failed
+	if changed {
+		sc.cert.Store(pair)
+		klog.Infof("Loaded self-signed certificate from secret %s/%s (serial: %v, expires: %v)",
+			sc.namespace, sc.name, pair.Leaf.SerialNumber, pair.Leaf.NotAfter)
+	}
+	return err
+}
+
+func (sc *selfSignedCerts) ensureSecret() (*corev1.Secret, error) {
+	secrets := sc.client.CoreV1().Secrets(sc.namespace)
+	secret, err := secrets.Get(sc.name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && !sc.needsRotation(secret) {
+		return secret, nil
+	}
+
+	var previousCA []byte
+	if err == nil {
+		previousCA = secret.Data[secretCAKey]
+	}
+	data, genErr := sc.generate(previousCA)
+	if genErr != nil {
+		return nil, genErr
+	}
+
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: sc.namespace,
+				Name:      sc.name,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}
+		created, err := secrets.Create(secret)
+		if apierrors.IsAlreadyExists(err) {
+			// another replica was faster, use its pair
+			return secrets.Get(sc.name, metav1.GetOptions{})
+		}
+		if err == nil {
+			klog.Infof("Created secret %s/%s with a new self-signed CA and certificate", sc.namespace, sc.name)
+		}
+		return created, err
+	}
+
+	secret.Data = data
+	updated, err := secrets.Update(secret)
+	if apierrors.IsConflict(err) {
+		// another replica rotated it in the meantime
+		return secrets.Get(sc.name, metav1.GetOptions{})
+	}
+	if err == nil {
+		klog.Infof("Rotated self-signed CA and certificate in secret %s/%s", sc.namespace, sc.name)
+	}
+	return updated, err
+}
+
+func (sc *selfSignedCerts) needsRotation(secret *corev1.Secret) bool {
+	pair, err := parseKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		klog.Warningf("Invalid key pair in secret %s/%s, generating a new one: %v", sc.namespace, sc.name, err)
+		return true
+	}
+	if len(secret.Data[secretCAKey]) == 0 {
+		return true
+	}
+	return pair.Leaf.NotAfter.Sub(sc.now()) < selfSignedCertRotateBefore
+}
+
+// generate returns the Secret data with a new CA and a serving certificate,
+// the previous CA is kept in the bundle while still valid
+func (sc *selfSignedCerts) generate(previousCA []byte) (map[string][]byte, error) {
+	now := sc.now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("%s-ca@%d", sc.name, now.Unix())},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedCertValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: sc.dnsNames[len(sc.dnsNames)-2]},
+		DNSNames:     sc.dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	for _, old := range parseCertificates(previousCA) {
+		if old.IsCA && now.Before(old.NotAfter) {
+			bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: old.Raw})...)
+			break // only the last one
+		}
+	}
+
+	return map[string][]byte{
+		secretCAKey:             bundle,
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// webhookConfiguration is a Mutating or Validating WebhookConfiguration, with
+// the CA bundles of its webhooks and the way to save them
+type webhookConfiguration struct {
+	caBundles []*[]byte
+	update    func() error
+}
+
+// webhookConfigurationKind gets the configurations of a kind by name
+type webhookConfigurationKind struct {
+	kind  string
+	names []string
+	get   func(name string) (*webhookConfiguration, error)
+}
+
+func (sc *selfSignedCerts) injectCABundle(bundle []byte) error {
+	client := sc.client.AdmissionregistrationV1()
+	kinds := []webhookConfigurationKind{
+		{
+			kind:  "MutatingWebhookConfiguration",
+			names: sc.mutatingConfigs,
+			get: func(name string) (*webhookConfiguration, error) {
+				whc, err := client.MutatingWebhookConfigurations().Get(name, metav1.GetOptions{})
+				if err != nil {
+					return nil, err
+				}
+				wc := &webhookConfiguration{update: func() error {
+					_, err := client.MutatingWebhookConfigurations().Update(whc)
+					return err
+				}}
+				for i := range whc.Webhooks {
+					wc.caBundles = append(wc.caBundles, &whc.Webhooks[i].ClientConfig.CABundle)
+				}
+				return wc, nil
+			},
+		},
+		{
+			kind:  "ValidatingWebhookConfiguration",
+			names: sc.validatingConfigs,
+			get: func(name string) (*webhookConfiguration, error) {
+				whc, err := client.ValidatingWebhookConfigurations().Get(name, metav1.GetOptions{})
+				if err != nil {
+					return nil, err
+				}
+				wc := &webhookConfiguration{update: func() error {
+					_, err := client.ValidatingWebhookConfigurations().Update(whc)
+					return err
+				}}
+				for i := range whc.Webhooks {
+					wc.caBundles = append(wc.caBundles, &whc.Webhooks[i].ClientConfig.CABundle)
+				}
+				return wc, nil
+			},
+		},
+	}
+
+	var errs []string
+	for _, k := range kinds {
+		for _, name := range k.names {
+			if err := injectCABundleInto(k, name, bundle); err != nil {
+				errs = append(errs, fmt.Sprintf("%s %s: %v", k.kind, name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to inject caBundle: %v", errs)
+	}
+	return nil
+}
+
+// injectCABundleInto sets the bundle in all the webhooks of the configuration
+func injectCABundleInto(k webhookConfigurationKind, name string, bundle []byte) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		wc, err := k.get(name)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, caBundle := range wc.caBundles {
+			if !bytes.Equal(*caBundle, bundle) {
+				*caBundle = bundle
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		klog.Infof("Updating caBundle of %s %s", k.kind, name)
+		return wc.update()
+	})
+}
+
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &pair, nil
+}
+
+func parseCertificates(data []byte) (certs []*x509.Certificate) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
+
+func newSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
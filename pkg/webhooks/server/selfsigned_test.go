@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func TestSelfSignedCertsRotation(t *testing.T) {
+	client := fake.NewSimpleClientset(&admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhooks"},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "a.webhooks"}, {Name: "b.webhooks"}},
+	})
+	failUpdates := false
+	client.PrependReactor("update", "mutatingwebhookconfigurations",
+		func(k8stesting.Action) (bool, runtime.Object, error) {
+			if failUpdates {
+				return true, nil, errors.New("unavailable")
+			}
+			return false, nil, nil
+		})
+
+	now := time.Now()
+	sc := newSelfSignedCerts(client, &WebhookServerConfig{
+		ServiceName:                   "webhooks",
+		CertsSecretNamespace:          "default",
+		CertsSecretName:               "webhooks-certs",
+		MutatingWebhookConfigurations: []string{"webhooks"},
+	})
+	sc.now = func() time.Time { return now }
+
+	served := func() *tls.Certificate {
+		cert, err := sc.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("no certificate served: %v", err)
+		}
+		return cert
+	}
+	caBundles := func() [][]byte {
+		whc, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("webhooks", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bundles [][]byte
+		for _, wh := range whc.Webhooks {
+			bundles = append(bundles, wh.ClientConfig.CABundle)
+		}
+		return bundles
+	}
+	trusted := func(cert *tls.Certificate) bool {
+		for _, bundle := range caBundles() {
+			found := false
+			for _, ca := range parseCertificates(bundle) {
+				if bytes.Equal(cert.Leaf.RawIssuer, ca.RawSubject) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := sc.Sync(); err != nil {
+		t.Fatalf("initial sync: %v", err)
+	}
+	initial := served()
+	if !trusted(initial) {
+		t.Fatal("the CA bundles don't trust the initial certificate")
+	}
+
+	// close to expiration, the secret is rotated but the bundles can't be updated
+	now = initial.Leaf.NotAfter.Add(-selfSignedCertRotateBefore / 2)
+	failUpdates = true
+	if err := sc.Sync(); err == nil {
+		t.Fatal("expected the sync to fail while the bundles can't be updated")
+	}
+	if !sameCertificate(served(), initial) {
+		t.Fatal("the rotated certificate is served before the bundles trust it")
+	}
+
+	failUpdates = false
+	if err := sc.Sync(); err != nil {
+		t.Fatalf("sync after recovery: %v", err)
+	}
+	rotated := served()
+	if sameCertificate(rotated, initial) {
+		t.Fatal("the rotated certificate isn't served")
+	}
+	if !trusted(rotated) || !trusted(initial) {
+		t.Fatal("the CA bundles must trust both the rotated and the previous certificate")
+	}
+}
+
+func TestSelfSignedCertsFirstLoad(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	sc := newSelfSignedCerts(client, &WebhookServerConfig{
+		ServiceName:                   "webhooks",
+		CertsSecretNamespace:          "default",
+		CertsSecretName:               "webhooks-certs",
+		MutatingWebhookConfigurations: []string{"missing"},
+	})
+
+	// nothing is served yet, so the pair is loaded even if the bundles fail
+	if err := sc.Sync(); err == nil {
+		t.Fatal("expected the sync to fail on a missing configuration")
+	}
+	if !sc.Loaded() {
+		t.Fatal("the pair must be loaded when there's nothing served yet")
+	}
+}
@@ -6,15 +6,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"k8s.io/klog"
 
+	admissionV1 "k8s.io/api/admission/v1"
 	admissionV1beta1 "k8s.io/api/admission/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 
+	"github.com/trilogy-group/k8s-webhooks/pkg/dynamicpluglins"
 	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
 )
 
@@ -26,16 +34,29 @@ var (
 
 func init() {
 	runtimeScheme = runtime.NewScheme()
+	utilruntime.Must(admissionV1.AddToScheme(runtimeScheme))
+	utilruntime.Must(admissionV1beta1.AddToScheme(runtimeScheme))
 	codecs = serializer.NewCodecFactory(runtimeScheme)
 	deserializer = codecs.UniversalDeserializer()
 }
 
 type webhookServer struct {
-	server    *http.Server
-	config    *WebhookServerConfig
-	handlers  HandlersMap
-	stopCh    chan struct{}
-	factories FactoriesMap
+	server        *http.Server
+	metricsServer *http.Server
+	certs         certSource
+	config        *WebhookServerConfig
+	clients       clientProvider
+	handlers      handlerRegistry
+	stopCh        chan struct{}
+
+	namespaceLabels NamespaceLabelsFunc
+	admitPolicies   atomic.Value // *AdmitPolicies
+
+	factoriesLock    sync.RWMutex
+	factories        FactoriesMap
+	dynamicFactories DynamicFactoriesMap
+	factoriesRunning bool
+	startedFactories map[string]bool
 }
 
 func (whsrv *webhookServer) GetConfig() *WebhookServerConfig {
@@ -44,19 +65,32 @@ func (whsrv *webhookServer) GetConfig() *WebhookServerConfig {
 
 func (whsrv *webhookServer) Shutdown(ctxt context.Context) error {
 	close(whsrv.stopCh)
+	if whsrv.metricsServer != nil {
+		if err := whsrv.metricsServer.Shutdown(ctxt); err != nil {
+			klog.Errorf("Metrics server shutdown failed: %v", err)
+		}
+	}
 	return whsrv.server.Shutdown(ctxt)
 }
 
 func (whsrv *webhookServer) Start() error {
-	for fn, _ := range whsrv.factories {
-		if err := whsrv.StartFactory(fn); err != nil {
-			return err
+	go whsrv.certs.Run(whsrv.stopCh)
+
+	if dir := whsrv.config.PluginsDir; dir != "" {
+		for name, err := range dynamicpluglins.LoadPlugins(whsrv, dir, whsrv.config.HandlersMapYAML, whsrv.stopCh) {
+			klog.Errorf("Can't load plugin %s: %v", name, err)
 		}
 	}
 
+	// caches are synced in background, /readyz tells when we're done
+	whsrv.runFactories()
+
 	mux := http.NewServeMux()
+	mux.HandleFunc(healthzPath, whsrv.healthz)
+	mux.HandleFunc(readyzPath, whsrv.readyz)
 	mux.HandleFunc("/", whsrv.serve)
 	whsrv.server.Handler = mux
+	whsrv.startMetricsServer()
 
 	return whsrv.server.ListenAndServeTLS("", "")
 }
@@ -65,98 +99,223 @@ func (whsrv *webhookServer) Start() error {
 func (whsrv *webhookServer) serve(w http.ResponseWriter, r *http.Request) {
 	klog.Infof("Start Serving request: %s", r.URL.Path)
 
+	rhs := whsrv.lookupHandlers(r.URL.Path)
+	rh := rhs[0]
+
+	if r.Method != http.MethodPost {
+		klog.Errorf("Method=%s, expect POST", r.Method)
+		writeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed,
+			fmt.Sprintf("method %s not allowed, expect POST", r.Method))
+		return
+	}
+
+	// verify the content type is accurate
+	contentType := r.Header.Get("Content-Type")
+	if err := checkContentType(contentType); err != nil {
+		klog.Errorf("Content-Type=%s: %v", contentType, err)
+		writeStatus(w, http.StatusUnsupportedMediaType, metav1.StatusReasonUnsupportedMediaType, err.Error())
+		return
+	}
+
 	var body []byte
 	if r.Body != nil {
-		if data, err := ioutil.ReadAll(r.Body); err == nil {
-			body = data
+		reader := r.Body
+		maxBytes := whsrv.config.MaxRequestBodyBytes
+		if maxBytes > 0 {
+			reader = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			admissionDecodeFailures.WithLabelValues(rh.path).Inc()
+			klog.Errorf("Can't read body: %v", err)
+			if maxBytes > 0 && int64(len(data)) >= maxBytes {
+				writeStatus(w, http.StatusRequestEntityTooLarge, metav1.StatusReasonRequestEntityTooLarge,
+					fmt.Sprintf("body exceeds %d bytes", maxBytes))
+			} else {
+				writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest,
+					fmt.Sprintf("could not read body: %v", err))
+			}
+			return
 		}
+		body = data
 	}
 	if len(body) == 0 {
+		admissionDecodeFailures.WithLabelValues(rh.path).Inc()
 		klog.Error("empty body")
-		http.Error(w, "empty body", http.StatusBadRequest)
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, "empty body")
 		return
 	}
 
-	// verify the content type is accurate
-	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		klog.Errorf("Content-Type=%s, expect application/json", contentType)
-		http.Error(w, "invalid Content-Type, expect `application/json`", http.StatusUnsupportedMediaType)
+	obj, gvk, err := deserializer.Decode(body, nil, nil)
+	if err != nil {
+		admissionDecodeFailures.WithLabelValues(rh.path).Inc()
+		klog.Errorf("Can't decode body: %v", err)
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest,
+			fmt.Sprintf("could not decode body: %v", err))
 		return
 	}
 
-	var admissionResponse *admissionV1beta1.AdmissionResponse
-	ar := admissionV1beta1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
-		klog.Errorf("Can't decode body: %v", err)
-		admissionResponse = &admissionV1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
+	ar := &AdmissionReview{APIVersion: gvk.GroupVersion().String(), Path: r.URL.Path}
+	switch in := obj.(type) {
+	case *admissionV1.AdmissionReview:
+		ar.Request = in.Request
+	case *admissionV1beta1.AdmissionReview:
+		ar.Request = RequestFromV1beta1(in.Request)
+	default:
+		admissionDecodeFailures.WithLabelValues(rh.path).Inc()
+		klog.Errorf("Unsupported object: %v", gvk)
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest,
+			fmt.Sprintf("unsupported object %v, expect an AdmissionReview", gvk))
+		return
+	}
+	if ar.Request == nil {
+		admissionDecodeFailures.WithLabelValues(rh.path).Inc()
+		klog.Error("AdmissionReview without request")
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, "AdmissionReview without request")
+		return
 	}
 
-	// try handlers
-	handler := whsrv.GetHandlerForPath(r.URL.Path)
-	admissionResponse = handler(&ar)
-
-	admissionReview := admissionV1beta1.AdmissionReview{}
+	admissionResponse := whsrv.runHandlers(whsrv.selectHandlers(rhs, ar), ar)
 	if admissionResponse != nil {
-		admissionReview.Response = admissionResponse
-		if ar.Request != nil {
-			admissionReview.Response.UID = ar.Request.UID
-		}
+		admissionResponse.UID = ar.Request.UID
 	}
+	admissionReview := newAdmissionReviewResponse(gvk, admissionResponse)
 
 	resp, err := json.Marshal(admissionReview)
 	if err != nil {
 		klog.Errorf("Can't encode response: %v", err)
-		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError,
+			fmt.Sprintf("could not encode response: %v", err))
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(resp); err != nil {
+		// headers are already sent, nothing more we can tell the client
 		klog.Errorf("Can't write response: %v", err)
-		http.Error(w, fmt.Sprintf("could not write response: %v", err), http.StatusInternalServerError)
 	} else {
 		klog.Infof("Response written! (was %v)", admissionReview)
 	}
 
 }
 
+// checkContentType accepts only JSON, with an optional utf-8 charset
+func checkContentType(contentType string) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid Content-Type %q, expect `application/json`: %v", contentType, err)
+	}
+	if mediaType != "application/json" {
+		return fmt.Errorf("invalid Content-Type %q, expect `application/json`", contentType)
+	}
+	for param, value := range params {
+		if param != "charset" || !strings.EqualFold(value, "utf-8") {
+			return fmt.Errorf("unsupported Content-Type parameter %s=%s, only charset=utf-8 is accepted", param, value)
+		}
+	}
+	return nil
+}
+
+// writeStatus answers the requests that can't be handled with a
+// metav1.Status, using its code as the HTTP status code
+func writeStatus(w http.ResponseWriter, code int32, reason metav1.StatusReason, message string) {
+	status := metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Code:     code,
+		Reason:   reason,
+		Message:  message,
+	}
+	resp, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, message, int(code))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(code))
+	if _, err := w.Write(resp); err != nil {
+		klog.Errorf("Can't write response: %v", err)
+	}
+}
+
+// newAdmissionReviewResponse wraps the response in an AdmissionReview of the
+// same group, version and kind the request was received with
+func newAdmissionReviewResponse(gvk *schema.GroupVersionKind, resp *admissionV1.AdmissionResponse) runtime.Object {
+	if gvk.GroupVersion() == admissionV1beta1.SchemeGroupVersion {
+		return &admissionV1beta1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind},
+			Response: ResponseToV1beta1(resp),
+		}
+	}
+	return &admissionV1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind},
+		Response: resp,
+	}
+}
+
 var _ WebhookServer = &webhookServer{}
 
 func NewWebhookServer(config *WebhookServerConfig, params *WhSrvParameters) WebhookServer {
+	return NewWebhookServerWithOptions(config, params)
+}
+
+// NewWebhookServerWithOptions applies the options before setting up the
+// certificates and the ConfigMap, so they use the clients given as options
+func NewWebhookServerWithOptions(config *WebhookServerConfig, params *WhSrvParameters, options ...WebhookServerOption) WebhookServer {
 	if config == nil {
 		config = NewDefaultWebhookServerConfig()
 	}
 	if params == nil {
 		params = NewDefaultWebhookServerParameters()
 	}
-	pair, err := tls.LoadX509KeyPair(params.CertFile, params.KeyFile)
-	if err != nil {
-		klog.Fatalf("Failed to load key pair: %v", err)
-	}
-
 	ws := &webhookServer{
-		config: config,
-		stopCh: make(chan struct{}),
+		config:  config,
+		clients: clientProvider{config: config},
+		stopCh:  make(chan struct{}),
 		server: &http.Server{
-			Addr:      fmt.Sprintf(":%v", params.Port),
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{pair}},
+			Addr: fmt.Sprintf(":%v", params.Port),
 		},
 	}
+	if err := ws.setAdmitPolicies(config.DefaultAdmitPolicy, config.AdmitPolicies); err != nil {
+		// fail closed, rather than admitting what the policies would reject
+		klog.Errorf("Invalid admit policies, falling back to %s: %v", AdmitPolicyNever, err)
+		ws.setAdmitPolicies(string(AdmitPolicyNever), nil)
+	}
+	if params.MetricsPort > 0 {
+		ws.metricsServer = &http.Server{Addr: fmt.Sprintf(":%v", params.MetricsPort)}
+	}
 
+	var whsrv WebhookServer = ws
+	for _, opt := range options {
+		whsrv = opt(whsrv)
+	}
+
+	ws.setupCerts(params)
 	if config.UseConfigMap {
 		ws.setupConfigMap()
 	}
-
-	return ws
+	return whsrv
 }
 
-func NewWebhookServerWithOptions(config *WebhookServerConfig, params *WhSrvParameters, options ...WebhookServerOption) WebhookServer {
-	whsrv := NewWebhookServer(config, params)
-	for _, opt := range options {
-		whsrv = opt(whsrv)
+// setupCerts loads the key pair, a missing or broken one is not fatal, the
+// source keeps trying
+func (ws *webhookServer) setupCerts(params *WhSrvParameters) {
+	if ws.config.SelfSignedCerts {
+		cs, err := ws.KubeClient()
+		if err != nil {
+			klog.Fatalf("Can't get a client to manage the self-signed certificates: %v", err)
+		}
+		sc := newSelfSignedCerts(cs, ws.config)
+		if err := sc.Sync(); err != nil {
+			klog.Errorf("Failed to setup self-signed certificates: %v", err)
+		}
+		ws.certs = sc
+	} else {
+		cw := newCertWatcher(params.CertFile, params.KeyFile)
+		if err := cw.Load(); err != nil {
+			klog.Errorf("Failed to load key pair: %v", err)
+		}
+		ws.certs = cw
 	}
-	return whsrv
+	ws.server.TLSConfig = &tls.Config{GetCertificate: ws.certs.GetCertificate}
 }
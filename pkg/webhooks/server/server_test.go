@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	admissionV1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	. "github.com/trilogy-group/k8s-webhooks/pkg/webhooks"
+)
+
+func newTestServer(t *testing.T) *webhookServer {
+	t.Helper()
+	config := NewDefaultWebhookServerConfig()
+	config.UseConfigMap = false
+	whsrv := &webhookServer{config: config, stopCh: make(chan struct{})}
+	if err := whsrv.setAdmitPolicies(config.DefaultAdmitPolicy, config.AdmitPolicies); err != nil {
+		t.Fatal(err)
+	}
+	return whsrv
+}
+
+func postReview(t *testing.T, whsrv *webhookServer, path string, review interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	whsrv.serve(w, r)
+	return w
+}
+
+func TestServeAdmissionReviewVersions(t *testing.T) {
+	whsrv := newTestServer(t)
+	var got *AdmissionReview
+	whsrv.RegisterHandler("/validate", func(ar *AdmissionReview) *admissionV1.AdmissionResponse {
+		got = ar
+		return &admissionV1.AdmissionResponse{Allowed: true}
+	})
+
+	t.Run("v1", func(t *testing.T) {
+		w := postReview(t, whsrv, "/validate", &admissionV1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request:  &admissionV1.AdmissionRequest{UID: types.UID("v1-uid"), Operation: admissionV1.Create},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body)
+		}
+		if got.APIVersion != "admission.k8s.io/v1" || got.Request.Operation != admissionV1.Create {
+			t.Errorf("handler got %s %+v", got.APIVersion, got.Request)
+		}
+		resp := admissionV1.AdmissionReview{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.APIVersion != "admission.k8s.io/v1" || resp.Kind != "AdmissionReview" {
+			t.Errorf("response %s %s, want admission.k8s.io/v1 AdmissionReview", resp.APIVersion, resp.Kind)
+		}
+		if resp.Response == nil || resp.Response.UID != "v1-uid" || !resp.Response.Allowed {
+			t.Errorf("response = %+v, want allowed with the request UID", resp.Response)
+		}
+	})
+
+	t.Run("v1beta1", func(t *testing.T) {
+		w := postReview(t, whsrv, "/validate", &admissionV1beta1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+			Request:  &admissionV1beta1.AdmissionRequest{UID: types.UID("v1beta1-uid"), Operation: admissionV1beta1.Update},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", w.Code, w.Body)
+		}
+		if got.APIVersion != "admission.k8s.io/v1beta1" || got.Request.Operation != admissionV1.Update {
+			t.Errorf("handler got %s %+v", got.APIVersion, got.Request)
+		}
+		resp := admissionV1beta1.AdmissionReview{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.APIVersion != "admission.k8s.io/v1beta1" {
+			t.Errorf("response apiVersion = %s, want admission.k8s.io/v1beta1", resp.APIVersion)
+		}
+		if resp.Response == nil || resp.Response.UID != "v1beta1-uid" || !resp.Response.Allowed {
+			t.Errorf("response = %+v, want allowed with the request UID", resp.Response)
+		}
+	})
+}
+
+func TestV1beta1Handler(t *testing.T) {
+	h := V1beta1Handler(func(ar *admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse {
+		pt := admissionV1beta1.PatchTypeJSONPatch
+		return &admissionV1beta1.AdmissionResponse{
+			Allowed:   ar.Request.Namespace == "default",
+			Patch:     []byte("[]"),
+			PatchType: &pt,
+		}
+	})
+	resp := h(&AdmissionReview{Request: &admissionV1.AdmissionRequest{Namespace: "default"}})
+	if !resp.Allowed || string(resp.Patch) != "[]" {
+		t.Errorf("response = %+v", resp)
+	}
+	if resp.PatchType == nil || *resp.PatchType != admissionV1.PatchTypeJSONPatch {
+		t.Errorf("patch type = %v, want %s", resp.PatchType, admissionV1.PatchTypeJSONPatch)
+	}
+}
+
+func TestServeMalformedRequests(t *testing.T) {
+	v1Review := `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"uid"}}`
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		wantCode    int
+		wantReason  metav1.StatusReason
+	}{
+		{name: "GET", method: http.MethodGet, body: v1Review, wantCode: http.StatusMethodNotAllowed, wantReason: metav1.StatusReasonMethodNotAllowed},
+		{name: "not JSON", contentType: "text/plain", body: v1Review, wantCode: http.StatusUnsupportedMediaType, wantReason: metav1.StatusReasonUnsupportedMediaType},
+		{name: "other charset", contentType: "application/json; charset=latin1", body: v1Review, wantCode: http.StatusUnsupportedMediaType, wantReason: metav1.StatusReasonUnsupportedMediaType},
+		{name: "empty body", wantCode: http.StatusBadRequest, wantReason: metav1.StatusReasonBadRequest},
+		{name: "too large", body: v1Review + strings.Repeat(" ", 1024), wantCode: http.StatusRequestEntityTooLarge, wantReason: metav1.StatusReasonRequestEntityTooLarge},
+		{name: "not a review", body: `{"apiVersion":"v1","kind":"Pod"}`, wantCode: http.StatusBadRequest, wantReason: metav1.StatusReasonBadRequest},
+		{name: "invalid JSON", body: `{"apiVersion":`, wantCode: http.StatusBadRequest, wantReason: metav1.StatusReasonBadRequest},
+		{name: "without request", body: `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`, wantCode: http.StatusBadRequest, wantReason: metav1.StatusReasonBadRequest},
+		{name: "utf-8 charset", contentType: "application/json; charset=UTF-8", body: v1Review, wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			whsrv := newTestServer(t)
+			whsrv.config.MaxRequestBodyBytes = 1024
+			called := false
+			whsrv.RegisterHandler("/validate", func(*AdmissionReview) *admissionV1.AdmissionResponse {
+				called = true
+				return &admissionV1.AdmissionResponse{Allowed: true}
+			})
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			contentType := tt.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			r := httptest.NewRequest(method, "/validate", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+			whsrv.serve(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode == http.StatusOK {
+				if !called {
+					t.Error("handler not called")
+				}
+				return
+			}
+			if called {
+				t.Error("handler called on a malformed request")
+			}
+			status := metav1.Status{}
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+				t.Fatalf("answer is not a Status: %v", err)
+			}
+			if status.Kind != "Status" || status.Code != int32(tt.wantCode) || status.Reason != tt.wantReason {
+				t.Errorf("status = %+v, want code %d reason %s", status, tt.wantCode, tt.wantReason)
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package webhooks
 
 import (
-	admissionV1beta1 "k8s.io/api/admission/v1beta1"
+	admissionV1 "k8s.io/api/admission/v1"
 )
 
 type PatchOperation struct {
@@ -10,10 +10,10 @@ type PatchOperation struct {
 	Value interface{} `json:"value,omitempty"`
 }
 
-func AdmitAlways(*admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse {
-	return &admissionV1beta1.AdmissionResponse{Allowed: true}
+func AdmitAlways(*AdmissionReview) *admissionV1.AdmissionResponse {
+	return &admissionV1.AdmissionResponse{Allowed: true}
 }
 
-func AdmitNever(*admissionV1beta1.AdmissionReview) *admissionV1beta1.AdmissionResponse {
-	return &admissionV1beta1.AdmissionResponse{Allowed: false}
+func AdmitNever(*AdmissionReview) *admissionV1.AdmissionResponse {
+	return &admissionV1.AdmissionResponse{Allowed: false}
 }